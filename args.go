@@ -1,6 +1,10 @@
 package mruby
 
-import "sync"
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
 
 // #include "gomruby.h"
 import "C"
@@ -26,6 +30,12 @@ func ArgsBlock() ArgSpec {
 	return ArgSpec(C._go_MRB_ARGS_BLOCK())
 }
 
+// ArgsKeyRest says that the function takes any keyword arguments, like
+// "**opts" in Ruby. Named keywords are declared with Keywords.
+func ArgsKeyRest() ArgSpec {
+	return ArgSpec(C._go_MRB_ARGS_KEY(0, 1))
+}
+
 // ArgsNone says it takes no arguments.
 func ArgsNone() ArgSpec {
 	return ArgSpec(C._go_MRB_ARGS_NONE())
@@ -41,12 +51,497 @@ func ArgsOpt(n int) ArgSpec {
 	return ArgSpec(C._go_MRB_ARGS_OPT(C.int(n)))
 }
 
-// The global accumulator when Mrb.GetArgs is called. There is a
-// global lock around this so that the access to it is safe.
-var getArgAccumulator []C.mrb_value
-var getArgLock = new(sync.Mutex)
+// keyRest tells you if the spec takes any keyword arguments.
+func (s ArgSpec) keyRest() bool {
+	return s&ArgsKeyRest() != 0
+}
+
+// Keywords declares the keyword arguments of a Go function, for
+// Class.DefineMethodKeywords and Class.DefineClassMethodKeywords.
+// Calling the function with an unknown keyword or without one of the
+// required keywords raises an ArgumentError, unless the ArgSpec includes
+// ArgsKeyRest, in which case unknown keywords are allowed.
+//
+// Keywords are read with GetArgsSpec.
+type Keywords struct {
+	Required []string
+	Optional []string
+}
+
+func (k Keywords) declares(name string) bool {
+	for _, list := range [][]string{k.Required, k.Optional} {
+		for _, n := range list {
+			if n == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// empty tells you if no keywords are declared.
+func (k Keywords) empty() bool {
+	return len(k.Required) == 0 && len(k.Optional) == 0
+}
+
+// hasKeywords tells you if the function takes keyword arguments.
+func (f methodFunc) hasKeywords() bool {
+	return f.spec.keyRest() || !f.keywords.empty()
+}
+
+// arity returns the arity of the function, the way Ruby reports it for
+// a method with the same arguments: the number of required arguments,
+// or -n-1 if it also takes optional ones.
+func (f methodFunc) arity() int {
+	// These are the positions of the fields of an mrb_aspec, which
+	// mruby only unpacks inside the VM.
+	s := f.spec
+	req := int(s>>18&0x1f) + int(s>>7&0x1f)
+	variable := s>>13&0x1f != 0 || s>>12&1 != 0
+
+	if f.hasKeywords() {
+		kw := f.keywords
+		if len(kw.Required) > 0 {
+			req++
+		}
+		if len(kw.Optional) > 0 || s.keyRest() {
+			variable = variable || len(kw.Required) == 0
+		}
+	}
+
+	if variable {
+		return -req - 1
+	}
+
+	return req
+}
+
+// Args are the arguments given to a Go function, split up according to
+// its ArgSpec. See GetArgsSpec.
+type Args struct {
+	// Positional are the positional arguments.
+	Positional []*MrbValue
+
+	// Keywords are the keyword arguments by name, without the colon. This
+	// is only set if the function declares keywords.
+	Keywords map[string]*MrbValue
+
+	// Block is the block given to the function, or nil.
+	Block *MrbValue
+}
+
+// GetArgsSpec returns the arguments given to the currently called
+// function, split up according to the ArgSpec and Keywords it was
+// defined with.
+//
+// If the function declares Keywords or ArgsKeyRest, a trailing Hash
+// with only Symbol keys is taken as the keyword arguments.
+// Functions that declare keywords are checked before they are called,
+// so the keywords are always valid by the time GetArgsSpec is called;
+// otherwise GetArgsSpec returns the ArgumentError as an *Exception.
+func (m *Mrb) GetArgsSpec() (*Args, error) {
+	defer enterState(m.state)()
+
+	var f methodFunc
+	if id := int(C._go_mrb_func_id(m.state)); id >= 0 && id < len(m.funcs) {
+		f = m.funcs[id]
+	}
+
+	args, exc := m.getArgsSpec(f)
+	if exc != nil {
+		return nil, exceptionError(m, exc)
+	}
+
+	return args, nil
+}
+
+// getArgsSpec splits up the arguments of the current function according
+// to the declaration of f, returning an ArgumentError to raise if the
+// keywords are wrong.
+func (m *Mrb) getArgsSpec(f methodFunc) (*Args, *MrbValue) {
+	positional, block := m.getArgs()
+	args := &Args{Positional: positional, Block: block}
+	if !f.hasKeywords() {
+		return args, nil
+	}
+
+	args.Keywords = make(map[string]*MrbValue)
+	if n := len(positional); n > 0 && positional[n-1].Type() == TypeHash {
+		keywords, ok, err := symbolHash(positional[n-1].Hash())
+		if err != nil {
+			return nil, err.(*Exception).MrbValue
+		}
+
+		if ok {
+			args.Positional = positional[:n-1]
+			args.Keywords = keywords
+		}
+	}
+
+	decl := f.keywords
+	for _, name := range decl.Required {
+		if _, ok := args.Keywords[name]; !ok {
+			return nil, m.newException("ArgumentError", "missing keyword: %s", name)
+		}
+	}
+
+	if !f.spec.keyRest() {
+		for name := range args.Keywords {
+			if !decl.declares(name) {
+				return nil, m.newException("ArgumentError", "unknown keyword: %s", name)
+			}
+		}
+	}
+
+	return args, nil
+}
+
+// exceptionError turns an exception value into an *Exception error.
+func exceptionError(m *Mrb, exc *MrbValue) error {
+	m.state.exc = C._go_mrb_getobj(exc.value)
+	return checkException(m.state)
+}
+
+// symbolHash converts a Hash whose keys are all Symbols into a map. ok is
+// false if any of the keys isn't a Symbol.
+func symbolHash(h *Hash) (map[string]*MrbValue, bool, error) {
+	keys, err := h.Keys()
+	if err != nil {
+		return nil, false, err
+	}
+
+	array := keys.Array()
+	result := make(map[string]*MrbValue, array.Len())
+	for i := 0; i < array.Len(); i++ {
+		key, err := array.Get(i)
+		if err != nil {
+			return nil, false, err
+		}
+		if key.Type() != TypeSymbol {
+			return nil, false, nil
+		}
+
+		value, err := h.Get(key)
+		if err != nil {
+			return nil, false, err
+		}
+
+		result[key.String()] = value
+	}
+
+	return result, true, nil
+}
+
+// ScanArgs reads the arguments given to the currently called function
+// into Go pointers, using the same format strings as mrb_get_args:
+//
+//	o  any object          **MrbValue
+//	C  class or module     **Class
+//	S  String              *string
+//	s  String, binary      *[]byte
+//	z  String              *string
+//	A  Array               **Array
+//	H  Hash                **Hash
+//	a  Array, as a slice   *[]*MrbValue
+//	f  Float or Fixnum     *float64 or *float32
+//	i  Fixnum or Float     pointer to any integer type
+//	b  truthiness          *bool
+//	n  Symbol or String    *string (the symbol name)
+//	&  the block           **MrbValue, nil if no block was given
+//	*  the remaining args  *[]*MrbValue
+//	|  the following arguments are optional
+//	?  whether the previous optional argument was given  *bool
+//
+// A "!" after S, s, z, A, H or C also accepts nil, which sets the
+// pointer to its zero value. The pointers of optional arguments that
+// weren't given are left alone, so they can be set to defaults first.
+// For example, with the format "S|i&" the function takes a String and
+// an optional Integer, plus a block.
+//
+// If the arguments don't match the format, the returned error is the
+// ArgumentError or TypeError as an *Exception, whose MrbValue a Func can
+// return as its exception. A format that doesn't match the pointers returns a
+// plain error.
+func (m *Mrb) ScanArgs(format string, ptrs ...interface{}) error {
+	defer enterState(m.state)()
+
+	args, block := m.getArgs()
+
+	// Count the arguments the format takes
+	req, opt, rest, optional := 0, 0, false, false
+	for _, c := range format {
+		switch c {
+		case '|':
+			optional = true
+		case '*':
+			rest = true
+		case '&', '?', '!':
+		default:
+			if optional {
+				opt++
+			} else {
+				req++
+			}
+		}
+	}
+
+	if len(args) < req || (!rest && len(args) > req+opt) {
+		expected := fmt.Sprintf("%d", req)
+		switch {
+		case rest:
+			expected += "+"
+		case opt > 0:
+			expected += fmt.Sprintf("..%d", req+opt)
+		}
+
+		return exceptionError(m, m.newException("ArgumentError",
+			"wrong number of arguments (%d for %s)", len(args), expected))
+	}
+
+	next := func() (interface{}, error) {
+		if len(ptrs) == 0 {
+			return nil, fmt.Errorf("not enough pointers for format %q", format)
+		}
+
+		ptr := ptrs[0]
+		ptrs = ptrs[1:]
+		return ptr, nil
+	}
+
+	given := false
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c == '|' {
+			continue
+		}
+
+		ptr, err := next()
+		if err != nil {
+			return err
+		}
+
+		switch c {
+		case '?':
+			p, ok := ptr.(*bool)
+			if !ok {
+				return fmt.Errorf("format %q: ? needs a *bool, got %T", format, ptr)
+			}
+
+			*p = given
+		case '&':
+			p, ok := ptr.(**MrbValue)
+			if !ok {
+				return fmt.Errorf("format %q: & needs a **MrbValue, got %T", format, ptr)
+			}
+
+			*p = block
+		case '*':
+			p, ok := ptr.(*[]*MrbValue)
+			if !ok {
+				return fmt.Errorf("format %q: * needs a *[]*MrbValue, got %T", format, ptr)
+			}
+
+			*p = args
+			args = nil
+		default:
+			nilOK := i+1 < len(format) && format[i+1] == '!'
+			if nilOK {
+				i++
+			}
+
+			given = len(args) > 0
+			if !given {
+				continue
+			}
+
+			if err := m.scanArg(c, nilOK, args[0], ptr); err != nil {
+				return err
+			}
+
+			args = args[1:]
+		}
+	}
+
+	if len(ptrs) > 0 {
+		return fmt.Errorf("too many pointers for format %q", format)
+	}
+
+	return nil
+}
+
+// scanArg converts a single argument for ScanArgs.
+func (m *Mrb) scanArg(c byte, nilOK bool, v *MrbValue, ptr interface{}) error {
+	typeError := func(expected string) error {
+		return exceptionError(m, m.newException("TypeError",
+			"wrong argument type %s (expected %s)", v.Class().Name(), expected))
+	}
+
+	badPtr := func() error {
+		return fmt.Errorf("%c needs a different pointer type than %T", c, ptr)
+	}
+
+	if nilOK && v.Type() == TypeNil {
+		p := reflect.ValueOf(ptr)
+		if p.Kind() != reflect.Ptr || p.IsNil() {
+			return badPtr()
+		}
+
+		p.Elem().Set(reflect.Zero(p.Elem().Type()))
+		return nil
+	}
+
+	switch c {
+	case 'o':
+		p, ok := ptr.(**MrbValue)
+		if !ok {
+			return badPtr()
+		}
+
+		*p = v
+	case 'C':
+		p, ok := ptr.(**Class)
+		if !ok {
+			return badPtr()
+		}
+		if t := v.Type(); t != TypeClass && t != TypeModule {
+			return typeError("Class")
+		}
+
+		*p = newClass(m, C._go_mrb_class_ptr(v.value))
+	case 'S', 'z':
+		p, ok := ptr.(*string)
+		if !ok {
+			return badPtr()
+		}
+		if v.Type() != TypeString {
+			return typeError("String")
+		}
+
+		*p = v.String()
+	case 's':
+		p, ok := ptr.(*[]byte)
+		if !ok {
+			return badPtr()
+		}
+		if v.Type() != TypeString {
+			return typeError("String")
+		}
+
+		*p = v.Bytes()
+	case 'A':
+		p, ok := ptr.(**Array)
+		if !ok {
+			return badPtr()
+		}
+		if v.Type() != TypeArray {
+			return typeError("Array")
+		}
+
+		*p = v.Array()
+	case 'H':
+		p, ok := ptr.(**Hash)
+		if !ok {
+			return badPtr()
+		}
+		if v.Type() != TypeHash {
+			return typeError("Hash")
+		}
+
+		*p = v.Hash()
+	case 'a':
+		p, ok := ptr.(*[]*MrbValue)
+		if !ok {
+			return badPtr()
+		}
+		if v.Type() != TypeArray {
+			return typeError("Array")
+		}
+
+		array := v.Array()
+		values := make([]*MrbValue, array.Len())
+		for i := range values {
+			item, err := array.Get(i)
+			if err != nil {
+				return err
+			}
+
+			values[i] = item
+		}
+
+		*p = values
+	case 'f':
+		var f float64
+		switch v.Type() {
+		case TypeFloat:
+			f = v.Float()
+		case TypeFixnum:
+			f = float64(v.Int64())
+		default:
+			return typeError("Float")
+		}
+
+		switch p := ptr.(type) {
+		case *float64:
+			*p = f
+		case *float32:
+			*p = float32(f)
+		default:
+			return badPtr()
+		}
+	case 'i':
+		var n int64
+		switch v.Type() {
+		case TypeFixnum:
+			n = v.Int64()
+		case TypeFloat:
+			f := v.Float()
+			if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return exceptionError(m, m.newException("RangeError",
+					"float %v out of range of integer", f))
+			}
+
+			n = int64(f)
+		default:
+			return typeError("Integer")
+		}
+
+		p := reflect.ValueOf(ptr)
+		if p.Kind() != reflect.Ptr || p.IsNil() {
+			return badPtr()
+		}
+
+		switch elem := p.Elem(); elem.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if elem.OverflowInt(n) {
+				return exceptionError(m, m.newException("RangeError",
+					"integer %d too big to convert to %s", n, elem.Type()))
+			}
+
+			elem.SetInt(n)
+		default:
+			return badPtr()
+		}
+	case 'b':
+		p, ok := ptr.(*bool)
+		if !ok {
+			return badPtr()
+		}
+
+		*p = v.Truthy()
+	case 'n':
+		p, ok := ptr.(*string)
+		if !ok {
+			return badPtr()
+		}
+		if t := v.Type(); t != TypeSymbol && t != TypeString {
+			return typeError("Symbol")
+		}
+
+		*p = v.String()
+	default:
+		return fmt.Errorf("unknown format character %q", c)
+	}
 
-//export goGetArgAppend
-func goGetArgAppend(v C.mrb_value) {
-	getArgAccumulator = append(getArgAccumulator, v)
+	return nil
 }
@@ -0,0 +1,248 @@
+package mruby
+
+import (
+	"testing"
+)
+
+func TestMrbGetArgsSpec(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var args *Args
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		var err error
+		args, err = m.GetArgsSpec()
+		if err != nil {
+			return nil, err.(*Exception).MrbValue
+		}
+
+		return nil, nil
+	}
+
+	kw := Keywords{Required: []string{"timeout"}, Optional: []string{"retries"}}
+	mrb.TopSelf().SingletonClass().DefineMethodKeywords("test", cb, ArgsReq(1)|ArgsBlock(), kw)
+
+	if _, err := mrb.LoadString(`test(1, timeout: 5) { }`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(args.Positional) != 1 || args.Positional[0].Fixnum() != 1 {
+		t.Fatalf("bad: %#v", args.Positional)
+	}
+	if len(args.Keywords) != 1 || args.Keywords["timeout"].Fixnum() != 5 {
+		t.Fatalf("bad: %#v", args.Keywords)
+	}
+	if args.Block == nil || args.Block.Type() != TypeProc {
+		t.Fatalf("bad: %#v", args.Block)
+	}
+
+	if _, err := mrb.LoadString(`test(1, timeout: 5, retries: 2)`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(args.Keywords) != 2 || args.Block != nil {
+		t.Fatalf("bad: %#v", args)
+	}
+
+	// A hash with keys that aren't symbols stays positional
+	args = nil
+	if _, err := mrb.LoadString(`test({"timeout" => 5})`); err == nil {
+		t.Fatal("should error for the missing keyword")
+	}
+	if args != nil {
+		t.Fatal("func should not be called")
+	}
+
+	cases := map[string]string{
+		`test(1)`:                      "missing keyword: timeout",
+		`test(1, timeout: 1, nope: 1)`: "unknown keyword: nope",
+	}
+	for code, expected := range cases {
+		_, err := mrb.LoadString(code)
+		exc, ok := err.(*Exception)
+		if !ok {
+			t.Fatalf("%s: bad: %#v", code, err)
+		}
+		if exc.Class().Name() != "ArgumentError" || exc.Message != expected {
+			t.Fatalf("%s: bad: %s", code, exc)
+		}
+	}
+}
+
+func TestMrbGetArgsSpec_keyRest(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var args *Args
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		args, _ = m.GetArgsSpec()
+		return nil, nil
+	}
+
+	mrb.TopSelf().SingletonClass().DefineMethod("test", cb, ArgsKeyRest())
+	if _, err := mrb.LoadString(`test(a: 1, b: 2)`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(args.Positional) != 0 || len(args.Keywords) != 2 {
+		t.Fatalf("bad: %#v", args)
+	}
+}
+
+func TestMrbGetArgsSpec_noKeywords(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var args *Args
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		args, _ = m.GetArgsSpec()
+		return nil, nil
+	}
+
+	// Without declared keywords, a trailing hash is just an argument
+	mrb.TopSelf().SingletonClass().DefineMethod("test", cb, ArgsAny())
+	if _, err := mrb.LoadString(`test(1, a: 1)`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(args.Positional) != 2 || args.Keywords != nil {
+		t.Fatalf("bad: %#v", args)
+	}
+}
+
+func TestMethodFuncKeywords(t *testing.T) {
+	f := methodFunc{spec: ArgsReq(1)}
+	if f.hasKeywords() || f.arity() != 1 {
+		t.Fatalf("bad: %#v", f)
+	}
+
+	cases := []struct {
+		spec     ArgSpec
+		keywords Keywords
+		arity    int
+	}{
+		{ArgsReq(1), Keywords{Required: []string{"a"}}, 2},
+		{ArgsReq(1), Keywords{Optional: []string{"a"}}, -2},
+		{ArgsReq(1) | ArgsKeyRest(), Keywords{}, -2},
+		{ArgsReq(1) | ArgsKeyRest(), Keywords{Required: []string{"a"}}, 2},
+	}
+	for _, tc := range cases {
+		f := methodFunc{spec: tc.spec, keywords: tc.keywords}
+		if !f.hasKeywords() {
+			t.Fatalf("should have keywords: %#v", tc)
+		}
+		if arity := f.arity(); arity != tc.arity {
+			t.Fatalf("bad arity %d: %#v", arity, tc)
+		}
+	}
+}
+
+func TestMrbScanArgs(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var (
+		s     string
+		i     int
+		iSet  bool
+		h     *Hash
+		block *MrbValue
+		err   error
+	)
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		s, i, iSet, h, block = "", 10, false, nil, nil
+		err = m.ScanArgs("S|i?H!&", &s, &i, &iSet, &h, &block)
+		if exc, ok := err.(*Exception); ok {
+			return nil, exc.MrbValue
+		}
+
+		return nil, nil
+	}
+	mrb.TopSelf().SingletonClass().DefineMethod("test", cb, ArgsAny())
+
+	if _, err := mrb.LoadString(`test("a")`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if s != "a" || i != 10 || iSet || h != nil || block != nil {
+		t.Fatalf("bad: %q %d %v %v %v", s, i, iSet, h, block)
+	}
+
+	if _, err := mrb.LoadString(`test("b", 2.5, {"a" => 1}) { }`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if s != "b" || i != 2 || !iSet || h == nil || block == nil {
+		t.Fatalf("bad: %q %d %v %v %v", s, i, iSet, h, block)
+	}
+
+	if _, err := mrb.LoadString(`test("c", 3, nil)`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if h != nil {
+		t.Fatalf("bad: %v", h)
+	}
+
+	cases := map[string]string{
+		`test`:                "ArgumentError",
+		`test("a", 1, {}, 2)`: "ArgumentError",
+		`test(1)`:             "TypeError",
+		`test("a", "b")`:      "TypeError",
+		`test("a", 1, [])`:    "TypeError",
+	}
+	for code, expected := range cases {
+		_, err := mrb.LoadString(code)
+		exc, ok := err.(*Exception)
+		if !ok {
+			t.Fatalf("%s: bad: %#v", code, err)
+		}
+		if name := exc.Class().Name(); name != expected {
+			t.Fatalf("%s: bad: %s (%s)", code, name, exc)
+		}
+	}
+}
+
+func TestMrbScanArgs_rest(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var (
+		name string
+		rest []*MrbValue
+		f    float64
+		b    bool
+		err  error
+	)
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		err = m.ScanArgs("nfb*", &name, &f, &b, &rest)
+		return nil, nil
+	}
+	mrb.TopSelf().SingletonClass().DefineMethod("test", cb, ArgsAny())
+
+	if _, err := mrb.LoadString(`test(:foo, 1, nil, 2, 3)`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if name != "foo" || f != 1 || b || len(rest) != 2 || rest[1].Fixnum() != 3 {
+		t.Fatalf("bad: %q %v %v %#v", name, f, b, rest)
+	}
+}
+
+func TestMrbScanArgs_badPointer(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var err error
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		var i int
+		err = m.ScanArgs("S", &i)
+		return nil, nil
+	}
+	mrb.TopSelf().SingletonClass().DefineMethod("test", cb, ArgsAny())
+
+	if _, err := mrb.LoadString(`test("a")`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if _, ok := err.(*Exception); ok {
+		t.Fatal("should not be a Ruby exception")
+	}
+}
@@ -12,6 +12,8 @@ type Array struct {
 
 // Len returns the length of the array.
 func (v *Array) Len() int {
+	defer enterState(v.state)()
+
 	return int(C.mrb_ary_len(v.state, v.value))
 }
 
@@ -20,6 +22,8 @@ func (v *Array) Len() int {
 // This does not copy the element. This is a pointer/reference directly
 // to the element in the array.
 func (v *Array) Get(idx int) (*MrbValue, error) {
+	defer enterState(v.state)()
+
 	result := C.mrb_ary_entry(v.value, C.mrb_int(idx))
 
 	val := newValue(v.state, result)
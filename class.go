@@ -13,23 +13,31 @@ type Class struct {
 	mrb   *Mrb
 }
 
+// MethodMissingFunc is the signature of a Go function that handles calls
+// to missing methods. See Class.SetMethodMissing.
+type MethodMissingFunc func(m *Mrb, self *MrbValue, name string, args []*MrbValue) (Value, Value)
+
+// RespondToMissingFunc is the signature of a Go function that tells you
+// if a missing method is handled anyway. See Class.SetRespondToMissing.
+type RespondToMissingFunc func(m *Mrb, self *MrbValue, name string, includePrivate bool) bool
+
 // DefineClassMethod defines a class-level method on the given class.
 func (c *Class) DefineClassMethod(name string, cb Func, as ArgSpec) {
-	insertMethod(c.mrb.state, c.class.c, name, cb)
+	c.DefineClassMethodKeywords(name, cb, as, Keywords{})
+}
 
-	cs := C.CString(name)
-	defer C.free(unsafe.Pointer(cs))
+// DefineClassMethodKeywords is like DefineClassMethod, but the method
+// also takes the given keyword arguments. See Keywords.
+func (c *Class) DefineClassMethodKeywords(name string, cb Func, as ArgSpec, kw Keywords) {
+	defer enterState(c.mrb.state)()
 
-	C.mrb_define_class_method(
-		c.mrb.state,
-		c.class,
-		cs,
-		C._go_mrb_func_t(),
-		C.mrb_aspec(as))
+	defineMethod(c.mrb, c.singletonClass(), name, methodFunc{f: cb, spec: as, keywords: kw})
 }
 
 // DefineConst defines a constant within this class.
 func (c *Class) DefineConst(name string, value Value) {
+	defer enterState(c.mrb.state)()
+
 	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
 
@@ -39,17 +47,109 @@ func (c *Class) DefineConst(name string, value Value) {
 
 // DefineMethod defines an instance method on the class.
 func (c *Class) DefineMethod(name string, cb Func, as ArgSpec) {
-	insertMethod(c.mrb.state, c.class, name, cb)
+	c.DefineMethodKeywords(name, cb, as, Keywords{})
+}
 
-	cs := C.CString(name)
-	defer C.free(unsafe.Pointer(cs))
+// DefineMethodKeywords is like DefineMethod, but the method also takes
+// the given keyword arguments. See Keywords.
+func (c *Class) DefineMethodKeywords(name string, cb Func, as ArgSpec, kw Keywords) {
+	defer enterState(c.mrb.state)()
 
-	C.mrb_define_method(
-		c.mrb.state,
-		c.class,
-		cs,
-		C._go_mrb_func_t(),
-		C.mrb_aspec(as))
+	defineMethod(c.mrb, c.class, name, methodFunc{f: cb, spec: as, keywords: kw})
+}
+
+// DefineModuleFunction defines a module function, like module_function
+// in Ruby: the function can be called on the module itself, and is a
+// private instance method in classes that include the module. mruby
+// 1.2 doesn't enforce method visibility, so there the instance method
+// can still be called with an explicit receiver. An error is returned
+// if c isn't a module.
+func (c *Class) DefineModuleFunction(name string, cb Func, as ArgSpec) error {
+	defer enterState(c.mrb.state)()
+
+	if !c.IsModule() {
+		return exceptionError(c.mrb, c.mrb.newException("TypeError",
+			"%s is not a module", c.Name()))
+	}
+
+	defineMethod(c.mrb, c.class, name, methodFunc{f: cb, spec: as})
+	_, err := c.MrbValue(c.mrb).Call("module_function", Symbol(name))
+	return err
+}
+
+// Include includes the module in this class, like "include" in Ruby.
+func (c *Class) Include(module *Class) error {
+	_, err := c.MrbValue(c.mrb).Call("include", module.MrbValue(c.mrb))
+	return err
+}
+
+// Prepend prepends the module to this class, like "prepend" in Ruby, so
+// its methods are found before the methods of the class itself.
+func (c *Class) Prepend(module *Class) error {
+	_, err := c.MrbValue(c.mrb).Call("prepend", module.MrbValue(c.mrb))
+	return err
+}
+
+// AliasMethod makes newName another name for the method oldName.
+func (c *Class) AliasMethod(newName, oldName string) error {
+	_, err := c.MrbValue(c.mrb).Call(
+		"alias_method", Symbol(newName), Symbol(oldName))
+	return err
+}
+
+// UndefMethod prevents instances of the class from responding to the
+// method, even if it is defined in a superclass. It is a NameError if
+// the method isn't defined.
+func (c *Class) UndefMethod(name string) error {
+	_, err := c.MrbValue(c.mrb).Call("undef_method", Symbol(name))
+	return err
+}
+
+// RemoveMethod removes the method from the class, so that the method of
+// a superclass is used instead if there is one. It is a NameError if the
+// class itself doesn't define the method.
+func (c *Class) RemoveMethod(name string) error {
+	_, err := c.MrbValue(c.mrb).Call("remove_method", Symbol(name))
+	return err
+}
+
+// SetMethodMissing handles calls to methods that instances of the class
+// don't have with a Go function, like defining method_missing in Ruby.
+// The function gets the name of the missing method and the arguments it
+// was called with. A block, if any, is available with Mrb.Block.
+//
+// To refuse a name, return a NoMethodError as the exception. Use
+// SetRespondToMissing to make respond_to? agree with the function.
+func (c *Class) SetMethodMissing(f MethodMissingFunc) {
+	c.DefineMethod("method_missing", func(m *Mrb, self *MrbValue) (Value, Value) {
+		args := m.GetArgs()
+		if len(args) == 0 || args[0].Type() != TypeSymbol {
+			return nil, m.newException("ArgumentError", "no method name given")
+		}
+
+		return f(m, self, args[0].String(), args[1:])
+	}, ArgsReq(1)|ArgsAny()|ArgsBlock())
+}
+
+// SetRespondToMissing tells respond_to? which methods are handled by the
+// function given to SetMethodMissing, like defining respond_to_missing?
+// in Ruby. includePrivate is true if private methods should count. The
+// name is given as a Symbol or, when respond_to? is called with a String
+// that isn't a known Symbol yet, as that String.
+func (c *Class) SetRespondToMissing(f RespondToMissingFunc) {
+	c.DefineMethod("respond_to_missing?", func(m *Mrb, self *MrbValue) (Value, Value) {
+		args := m.GetArgs()
+		if len(args) == 0 {
+			return nil, m.newException("ArgumentError", "no method name given")
+		}
+		if t := args[0].Type(); t != TypeSymbol && t != TypeString {
+			return nil, m.newException("TypeError",
+				"%s is not a symbol nor a string", args[0])
+		}
+
+		includePrivate := len(args) > 1 && args[1].Truthy()
+		return Bool(f(m, self, args[0].String(), includePrivate)), nil
+	}, ArgsReq(1)|ArgsOpt(1))
 }
 
 // MrbValue returns a *Value for this Class. *Values are sometimes required
@@ -60,6 +160,8 @@ func (c *Class) MrbValue(m *Mrb) *MrbValue {
 
 // New instantiates the class with the given args.
 func (c *Class) New(args ...Value) (*MrbValue, error) {
+	defer enterState(c.mrb.state)()
+
 	var argv []C.mrb_value
 	var argvPtr *C.mrb_value
 	if len(args) > 0 {
@@ -72,7 +174,11 @@ func (c *Class) New(args ...Value) (*MrbValue, error) {
 		argvPtr = &argv[0]
 	}
 
-	result := C.mrb_obj_new(c.mrb.state, c.class, C.mrb_int(len(argv)), argvPtr)
+	if len(argv) > maxFuncallArgs {
+		return c.mrb.sendSplat(c.MrbValue(c.mrb).value, C.mrb_sym(c.mrb.Intern("new")), argv, nil)
+	}
+
+	result := C._go_mrb_obj_new(c.mrb.state, c.class, C.mrb_int(len(argv)), argvPtr)
 	if exc := checkException(c.mrb.state); exc != nil {
 		return nil, exc
 	}
@@ -80,6 +186,148 @@ func (c *Class) New(args ...Value) (*MrbValue, error) {
 	return newValue(c.mrb.state, result), nil
 }
 
+// Name returns the full name of the class, such as "Foo::Bar". Anonymous
+// classes get a name like "#<Class:0x...>" from mruby.
+func (c *Class) Name() string {
+	defer enterState(c.mrb.state)()
+
+	name := C.mrb_class_name(c.mrb.state, c.class)
+	if name == nil {
+		return ""
+	}
+
+	return C.GoString(name)
+}
+
+// Superclass returns the superclass of the class, or nil if there isn't
+// one, such as for BasicObject or a module.
+func (c *Class) Superclass() *Class {
+	super := C._go_mrb_superclass(c.class)
+	if super == nil {
+		return nil
+	}
+
+	return newClass(c.mrb, super)
+}
+
+// Ancestors returns the classes and modules that are searched for
+// methods, in order, starting with the class itself.
+func (c *Class) Ancestors() ([]*Class, error) {
+	result, err := c.MrbValue(c.mrb).Call("ancestors")
+	if err != nil {
+		return nil, err
+	}
+
+	array := result.Array()
+	classes := make([]*Class, array.Len())
+	for i := range classes {
+		item, err := array.Get(i)
+		if err != nil {
+			return nil, err
+		}
+
+		classes[i] = newClass(c.mrb, C._go_mrb_class_ptr(item.value))
+	}
+
+	return classes, nil
+}
+
+// InstanceMethods returns the names of the public instance methods of
+// the class. If inherit is false, only the methods defined by the class
+// itself are returned.
+func (c *Class) InstanceMethods(inherit bool) ([]string, error) {
+	result, err := c.MrbValue(c.mrb).Call("instance_methods", Bool(inherit))
+	if err != nil {
+		return nil, err
+	}
+
+	return stringSlice(result)
+}
+
+// Constants returns the names of the constants defined in the class.
+func (c *Class) Constants() ([]string, error) {
+	result, err := c.MrbValue(c.mrb).Call("constants")
+	if err != nil {
+		return nil, err
+	}
+
+	return stringSlice(result)
+}
+
+// GetConst returns the value of a constant in the class. Like in Ruby,
+// the constant is also looked up in the ancestors of the class. It is a
+// NameError if it isn't defined.
+func (c *Class) GetConst(name string) (*MrbValue, error) {
+	defer enterState(c.mrb.state)()
+
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+
+	result := C._go_mrb_const_get(
+		c.mrb.state, c.MrbValue(c.mrb).value, C.mrb_intern_cstr(c.mrb.state, cs))
+	if err := checkException(c.mrb.state); err != nil {
+		return nil, err
+	}
+
+	return newValue(c.mrb.state, result), nil
+}
+
+// ClassVariable returns the value of a class variable, such as "@@foo".
+// It is a NameError if it isn't defined.
+func (c *Class) ClassVariable(name string) (*MrbValue, error) {
+	defer enterState(c.mrb.state)()
+
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+
+	result := C._go_mrb_cv_get(
+		c.mrb.state, c.MrbValue(c.mrb).value, C.mrb_intern_cstr(c.mrb.state, cs))
+	if err := checkException(c.mrb.state); err != nil {
+		return nil, err
+	}
+
+	return newValue(c.mrb.state, result), nil
+}
+
+// SetClassVariable sets the value of a class variable, such as "@@foo".
+func (c *Class) SetClassVariable(name string, value Value) error {
+	defer enterState(c.mrb.state)()
+
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+
+	C._go_mrb_cv_set(
+		c.mrb.state,
+		c.MrbValue(c.mrb).value,
+		C.mrb_intern_cstr(c.mrb.state, cs),
+		value.MrbValue(c.mrb).value)
+	return checkException(c.mrb.state)
+}
+
+// IsModule tells you if this is a module rather than a class.
+func (c *Class) IsModule() bool {
+	return c.MrbValue(c.mrb).Type() == TypeModule
+}
+
+// MethodDefined tells you if instances of the class have a public or
+// protected method with the given name, including inherited methods.
+func (c *Class) MethodDefined(name string) (bool, error) {
+	result, err := c.MrbValue(c.mrb).Call("method_defined?", Symbol(name))
+	if err != nil {
+		return false, err
+	}
+
+	return result.Truthy(), nil
+}
+
+// singletonClass returns the singleton class of this class, which is
+// where class methods are defined.
+func (c *Class) singletonClass() *C.struct_RClass {
+	sclass := C._go_mrb_singleton_class(
+		c.mrb.state, C.mrb_obj_value(unsafe.Pointer(c.class)))
+	return mustClass(c.mrb.classResult(sclass)).class
+}
+
 func newClass(mrb *Mrb, c *C.struct_RClass) *Class {
 	return &Class{
 		class: c,
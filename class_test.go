@@ -100,3 +100,592 @@ func TestClassValue(t *testing.T) {
 		t.Fatalf("bad: %d", value.Type())
 	}
 }
+
+func TestClassDefineMethod_dispatch(t *testing.T) {
+	cases := []string{
+		`Hello.new.foo`,
+		`Sub.new.foo`,
+		`Sub.bar`,
+		`Hello.new.aliased`,
+		`Hello.new.send(:foo)`,
+		`Sub.new.__send__(:foo)`,
+		`Copy.new.foo`,
+		`Other.new.foo`,
+		`Other.new.mixed`,
+	}
+
+	for _, tc := range cases {
+		mrb := NewMrb()
+
+		class := mrb.DefineClass("Hello", mrb.ObjectClass())
+		class.DefineMethod("foo", testCallback, ArgsNone())
+		class.DefineClassMethod("bar", testCallback, ArgsNone())
+		module := mrb.DefineModule("Mixin")
+		module.DefineMethod("mixed", testCallback, ArgsNone())
+
+		_, err := mrb.LoadString(`
+			class Sub < Hello; end
+			class Hello; alias_method :aliased, :foo; end
+			Copy = Hello.dup
+			class Other
+				include Mixin
+				define_method(:foo) { Hello.new.foo }
+			end
+		`)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		value, err := mrb.LoadString(tc)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc, err)
+		}
+		testCallbackResult(t, value)
+
+		mrb.Close()
+	}
+}
+
+func TestClassDefineMethod_methodObject(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	class := mrb.DefineClass("Hello", mrb.ObjectClass())
+	class.DefineMethod("foo", testCallback, ArgsNone())
+
+	// Copy's method table holds the same Go proc as Hello's
+	if _, err := mrb.LoadString(`Copy = Hello.dup`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	copied, err := mrb.LookupClass("Copy")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, c := range []*Class{class, copied} {
+		instance, err := c.New()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		method, err := instance.Method("foo")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		value, err := method.Call()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		testCallbackResult(t, value)
+		method.Release()
+
+		unbound, err := c.InstanceMethod("foo")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		method, err = unbound.Bind(instance)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		value, err = method.Call()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		testCallbackResult(t, value)
+		unbound.Release()
+	}
+}
+
+func TestClassDefineMethod_sameName(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	one := mrb.DefineClass("One", mrb.ObjectClass())
+	one.DefineMethod("foo", func(m *Mrb, self *MrbValue) (Value, Value) {
+		return Int(1), nil
+	}, ArgsNone())
+	two := mrb.DefineClass("Two", one)
+	two.DefineMethod("foo", func(m *Mrb, self *MrbValue) (Value, Value) {
+		return Int(2), nil
+	}, ArgsNone())
+
+	value, err := mrb.LoadString(`[One.new.foo, Two.new.foo]`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.String() != "[1, 2]" {
+		t.Fatalf("bad: %s", value)
+	}
+}
+
+func TestClassDefineMethod_redefine(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	class := mrb.DefineClass("Hello", nil)
+	class.DefineMethod("foo", testCallback, ArgsNone())
+	if err := class.AliasMethod("bar", "foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ai := mrb.ArenaSave()
+	for i := 0; i < 100; i++ {
+		i := i
+		class.DefineMethod("foo", func(m *Mrb, self *MrbValue) (Value, Value) {
+			return Int(i), nil
+		}, ArgsNone())
+		mrb.ArenaRestore(ai)
+	}
+	mrb.FullGC()
+
+	// The functions of the replaced methods are released, but the alias
+	// keeps the first one.
+	if n := len(mrb.funcs) - len(mrb.freeFuncs); n > 2 {
+		t.Fatalf("bad: %d live funcs", n)
+	}
+
+	value, err := mrb.LoadString(`[Hello.new.foo, Hello.new.bar]`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.String() != "[99, 42]" {
+		t.Fatalf("bad: %s", value)
+	}
+
+	// Freed slots are reused
+	n := len(mrb.funcs)
+	class.DefineMethod("baz", testCallback, ArgsNone())
+	if len(mrb.funcs) != n {
+		t.Fatalf("bad: %d", len(mrb.funcs))
+	}
+}
+
+func TestClassNew_rubyException(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	if _, err := mrb.LoadString(`
+		class Hello
+			def initialize; raise ArgumentError, "nope"; end
+		end
+	`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	class, err := mrb.LookupClass("Hello")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := class.New(); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestClassIntrospection(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	_, err := mrb.LoadString(`
+		module Greeting
+			def greet; end
+		end
+		class Base; end
+		module Outer
+			class Hello < Base
+				include Greeting
+				FOO = 1
+				@@count = 2
+				def hello; end
+			end
+		end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	class, err := mrb.LookupClass("Outer::Hello")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if name := class.Name(); name != "Outer::Hello" {
+		t.Fatalf("bad: %s", name)
+	}
+	if class.IsModule() {
+		t.Fatal("should not be a module")
+	}
+	if name := class.Superclass().Name(); name != "Base" {
+		t.Fatalf("bad superclass: %s", name)
+	}
+
+	ancestors, err := class.Ancestors()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(ancestors) < 3 ||
+		ancestors[0].Name() != "Outer::Hello" ||
+		ancestors[1].Name() != "Greeting" ||
+		ancestors[2].Name() != "Base" {
+		t.Fatalf("bad ancestors: %#v", ancestors)
+	}
+
+	methods, err := class.InstanceMethods(false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(methods) != 1 || methods[0] != "hello" {
+		t.Fatalf("bad: %#v", methods)
+	}
+
+	for _, name := range []string{"hello", "greet"} {
+		ok, err := class.MethodDefined(name)
+		if err != nil || !ok {
+			t.Fatalf("%s should be defined: %s", name, err)
+		}
+	}
+	if ok, _ := class.MethodDefined("nope"); ok {
+		t.Fatal("nope should not be defined")
+	}
+
+	constants, err := class.Constants()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(constants) != 1 || constants[0] != "FOO" {
+		t.Fatalf("bad: %#v", constants)
+	}
+
+	value, err := class.GetConst("FOO")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Fixnum() != 1 {
+		t.Fatalf("bad: %s", value)
+	}
+	if _, err := class.GetConst("NOPE"); err == nil {
+		t.Fatal("should error")
+	}
+
+	value, err = class.ClassVariable("@@count")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Fixnum() != 2 {
+		t.Fatalf("bad: %s", value)
+	}
+	if err := class.SetClassVariable("@@count", Int(3)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	value, err = class.ClassVariable("@@count")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Fixnum() != 3 {
+		t.Fatalf("bad: %s", value)
+	}
+	if _, err := class.ClassVariable("@@nope"); err == nil {
+		t.Fatal("should error")
+	}
+
+	module, err := mrb.LookupModule("Greeting")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !module.IsModule() {
+		t.Fatal("should be a module")
+	}
+	if module.Superclass() != nil {
+		t.Fatal("module should not have a superclass")
+	}
+}
+
+func TestClassInclude(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	module := mrb.DefineModule("Mixin")
+	module.DefineMethod("foo", testCallback, ArgsNone())
+	class := mrb.DefineClass("Hello", nil)
+	if err := class.Include(module); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	value, err := mrb.LoadString(`Hello.new.foo`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCallbackResult(t, value)
+
+	if err := class.Include(class); err == nil {
+		t.Fatal("including a class should error")
+	}
+}
+
+func TestClassPrepend(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	_, err := mrb.LoadString(`
+		class Hello
+			def foo; 1; end
+		end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	module := mrb.DefineModule("Mixin")
+	module.DefineMethod("foo", testCallback, ArgsNone())
+	class, err := mrb.LookupClass("Hello")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := class.Prepend(module); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	value, err := mrb.LoadString(`Hello.new.foo`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCallbackResult(t, value)
+}
+
+func TestClassAliasMethod(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	class := mrb.DefineClass("Hello", nil)
+	class.DefineMethod("foo", testCallback, ArgsNone())
+	if err := class.AliasMethod("bar", "foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	value, err := mrb.LoadString(`Hello.new.bar`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCallbackResult(t, value)
+
+	if err := class.AliasMethod("baz", "nope"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestClassUndefMethod(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	parent := mrb.DefineClass("Parent", nil)
+	parent.DefineMethod("foo", testCallback, ArgsNone())
+	class := mrb.DefineClass("Hello", parent)
+	if err := class.UndefMethod("foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := mrb.LoadString(`Hello.new.foo`); err == nil {
+		t.Fatal("should error")
+	}
+	value, err := mrb.LoadString(`Parent.new.foo`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCallbackResult(t, value)
+
+	if err := class.UndefMethod("nope"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestClassRemoveMethod(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	parent := mrb.DefineClass("Parent", nil)
+	parent.DefineMethod("foo", testCallback, ArgsNone())
+	class := mrb.DefineClass("Hello", parent)
+	class.DefineMethod("foo", testCallbackException, ArgsNone())
+	if err := class.RemoveMethod("foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	value, err := mrb.LoadString(`Hello.new.foo`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCallbackResult(t, value)
+
+	if err := class.RemoveMethod("foo"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestClassDefineModuleFunction(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	module := mrb.DefineModule("Util")
+	n := len(mrb.funcs)
+	if err := module.DefineModuleFunction("foo", testCallback, ArgsNone()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Like module_function, both methods share the one function
+	if len(mrb.funcs) != n+1 {
+		t.Fatalf("bad: %d", len(mrb.funcs)-n)
+	}
+
+	for _, code := range []string{
+		`Util.foo`,
+		`class Mixed; include Util; def bar; foo; end; end; Mixed.new.bar`,
+	} {
+		value, err := mrb.LoadString(code)
+		if err != nil {
+			t.Fatalf("%s: err: %s", code, err)
+		}
+		testCallbackResult(t, value)
+	}
+
+	// Classes don't have module functions
+	class := mrb.DefineClass("Hello", nil)
+	if err := class.DefineModuleFunction("foo", testCallback, ArgsNone()); err == nil {
+		t.Fatal("should error")
+	}
+
+	// And nothing is defined when it fails
+	value, err := mrb.LoadString(`Hello.new.respond_to?(:foo)`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Truthy() {
+		t.Fatal("foo should not be defined")
+	}
+}
+
+func TestClassNew_manyArgs(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	_, err := mrb.LoadString(`
+		class Hello
+			attr_reader :args
+			def initialize(*args); @args = args; end
+		end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	class, err := mrb.LookupClass("Hello")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	args := make([]Value, 100)
+	for i := range args {
+		args[i] = Int(i)
+	}
+
+	instance, err := class.New(args...)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	value, err := instance.Call("args")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n := value.Array().Len(); n != 100 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestClassSetMethodMissing(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	config := map[string]string{"host": "localhost", "port": "8080", "ghost": "boo"}
+
+	class := mrb.DefineClass("Config", nil)
+	class.SetMethodMissing(func(m *Mrb, self *MrbValue, name string, args []*MrbValue) (Value, Value) {
+		if name == "fetch" && len(args) == 1 {
+			if block, ok := m.Block(); ok {
+				result, err := m.Yield(block, String(config[args[0].String()]))
+				if err != nil {
+					return nil, err.(*Exception).MrbValue
+				}
+
+				return result, nil
+			}
+		}
+
+		value, ok := config[name]
+		if !ok || len(args) > 0 {
+			return nil, m.newException("NoMethodError", "undefined method '%s'", name)
+		}
+
+		return String(value), nil
+	})
+	class.SetRespondToMissing(func(m *Mrb, self *MrbValue, name string, includePrivate bool) bool {
+		_, ok := config[name]
+		return ok
+	})
+
+	result, err := mrb.LoadString(`
+		c = Config.new
+		[
+			c.host,
+			c.port,
+			c.fetch("host") { |v| v.upcase },
+			c.respond_to?(:host),
+			c.respond_to?(:nope),
+			(c.nope rescue $!.class),
+			c.respond_to?("host"),
+			# Built at runtime so that it isn't a Symbol yet
+			c.respond_to?("gh" + "ost"),
+			c.respond_to?("no" + "where"),
+		]
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `["localhost", "8080", "LOCALHOST", true, false, NoMethodError, true, true, false]`
+	if result.String() != expected {
+		t.Fatalf("bad: %s", result)
+	}
+
+	instance, err := class.New()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err = instance.Call("port")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.String() != "8080" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	if ok, err := instance.RespondTo("host"); err != nil || !ok {
+		t.Fatalf("bad: %v %s", ok, err)
+	}
+
+	// Method finds names that respond_to_missing? knows about
+	method, err := instance.Method("host")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result, err = method.Call()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.String() != "localhost" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	if _, err := instance.Method("nope"); err == nil {
+		t.Fatal("should error")
+	}
+}
@@ -7,11 +7,26 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // This is the tag to use with structures to have settings for mruby
 const tagName = "mruby"
 
+var (
+	procType  = reflect.TypeOf(Proc{})
+	rangeType = reflect.TypeOf(Range{})
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+// Range is the Go representation of a Ruby Range. Begin and End are
+// decoded the same way any value is decoded into an interface{}.
+type Range struct {
+	Begin     interface{}
+	End       interface{}
+	Exclusive bool
+}
+
 // Decode converts the Ruby value to a Go value.
 //
 // The Decode process may call Ruby code and may generate Ruby garbage,
@@ -27,6 +42,18 @@ const tagName = "mruby"
 // Hash and Arrays can map directly to maps and slices in Go, and Decode
 // will handle this as you expect.
 //
+// Arrays can also decode into fixed-size Go arrays as long as the lengths
+// match, and strings decode into []byte byte-for-byte, including any NUL
+// bytes or invalid UTF-8. A time.Time can be decoded from a Ruby Time
+// (when mruby is built with the mruby-time gem) or from an RFC3339 string.
+//
+// A few other Ruby types have special handling. nil decodes into the zero
+// value of whatever it is decoded into, so pointers and interfaces become
+// nil. Symbols decode into strings. Ranges decode into a Range or into a
+// two element array such as [2]int holding the endpoints (exclusivity is
+// only kept by Range). Procs decode into a *Proc that can be called later;
+// the proc must be kept alive by the caller for as long as it is used.
+//
 // The only remaining data type in Go is a struct. A struct in Go can map
 // to any object in Ruby. If the data in Ruby is a hash, then the struct keys
 // will map directly to the hash keys. If the data in Ruby is an object, then
@@ -44,6 +71,10 @@ const tagName = "mruby"
 //    }
 //
 func Decode(out interface{}, v *MrbValue) error {
+	if v != nil {
+		defer enterState(v.state)()
+	}
+
 	// The out parameter must be a pointer since we must be
 	// able to write to it.
 	val := reflect.ValueOf(out)
@@ -62,6 +93,26 @@ type decoder struct {
 type decodeStructGetter func(string) (*MrbValue, error)
 
 func (d *decoder) decode(name string, v *MrbValue, result reflect.Value) error {
+	// nil always decodes into the zero value, no matter the kind. Note
+	// that Array.Get returns a nil *MrbValue for nil elements.
+	if v == nil || v.Type() == TypeNil {
+		result.Set(reflect.Zero(result.Type()))
+		return nil
+	}
+
+	// Go structs wrapped by a class from DefineStructClass decode into
+	// the same pointer, or into a copy of the struct.
+	if ptr, ok := unwrapStruct(v); ok {
+		switch {
+		case ptr.Type().AssignableTo(result.Type()):
+			result.Set(ptr)
+			return nil
+		case ptr.Elem().Type().AssignableTo(result.Type()):
+			result.Set(ptr.Elem())
+			return nil
+		}
+	}
+
 	k := result
 
 	// If we have an interface with a valid value, we use that
@@ -83,12 +134,24 @@ func (d *decoder) decode(name string, v *MrbValue, result reflect.Value) error {
 		}()
 	}
 
+	// Some struct types have their own Ruby counterparts
+	switch k.Type() {
+	case procType:
+		return d.decodeProc(name, v, result)
+	case rangeType:
+		return d.decodeRange(name, v, result)
+	case timeType:
+		return d.decodeTime(name, v, result)
+	}
+
 	switch k.Kind() {
+	case reflect.Array:
+		return d.decodeArray(name, v, result)
 	case reflect.Bool:
 		return d.decodeBool(name, v, result)
 	case reflect.Float64:
 		return d.decodeFloat(name, v, result)
-	case reflect.Int:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		return d.decodeInt(name, v, result)
 	case reflect.Interface:
 		// When we see an interface, we make our own thing
@@ -110,6 +173,50 @@ func (d *decoder) decode(name string, v *MrbValue, result reflect.Value) error {
 		"%s: unknown kind to decode into: %s", name, k.Kind())
 }
 
+func (d *decoder) decodeArray(name string, v *MrbValue, result reflect.Value) error {
+	switch t := v.Type(); t {
+	case TypeArray:
+		array := v.Array()
+		if array.Len() != result.Len() {
+			return fmt.Errorf(
+				"%s: array length %d doesn't match Go array length %d",
+				name, array.Len(), result.Len())
+		}
+
+		for i := 0; i < array.Len(); i++ {
+			rbVal, err := array.Get(i)
+			if err != nil {
+				return err
+			}
+
+			fieldName := fmt.Sprintf("%s[%d]", name, i)
+			if err := d.decode(fieldName, rbVal, result.Index(i)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case TypeRange:
+		if result.Len() != 2 {
+			return fmt.Errorf(
+				"%s: range must decode into an array of length 2", name)
+		}
+
+		begin, end, _, err := rangeEdges(v)
+		if err != nil {
+			return err
+		}
+
+		if err := d.decode(name+".begin", begin, result.Index(0)); err != nil {
+			return err
+		}
+
+		return d.decode(name+".end", end, result.Index(1))
+	default:
+		return fmt.Errorf("%s: unknown type to array: %v", name, t)
+	}
+}
+
 func (d *decoder) decodeBool(name string, v *MrbValue, result reflect.Value) error {
 	switch t := v.Type(); t {
 	case TypeFalse:
@@ -135,20 +242,34 @@ func (d *decoder) decodeFloat(name string, v *MrbValue, result reflect.Value) er
 }
 
 func (d *decoder) decodeInt(name string, v *MrbValue, result reflect.Value) error {
+	// If we're decoding into an interface, the interface was already
+	// set to the integer type we want by decodeInterface.
+	resultType := result.Type()
+	if result.Kind() == reflect.Interface {
+		resultType = result.Elem().Type()
+	}
+
+	var n int64
 	switch t := v.Type(); t {
 	case TypeFixnum:
-		result.Set(reflect.ValueOf(v.Fixnum()))
+		n = v.Int64()
 	case TypeString:
-		v, err := strconv.ParseInt(v.String(), 0, 0)
+		var err error
+		n, err = strconv.ParseInt(v.String(), 0, resultType.Bits())
 		if err != nil {
 			return err
 		}
-
-		result.SetInt(int64(v))
 	default:
 		return fmt.Errorf("%s: unknown type %v", name, t)
 	}
 
+	val := reflect.New(resultType).Elem()
+	if val.OverflowInt(n) {
+		return fmt.Errorf("%s: %d overflows %s", name, n, resultType)
+	}
+
+	val.SetInt(n)
+	result.Set(val)
 	return nil
 }
 
@@ -184,7 +305,15 @@ func (d *decoder) decodeInterface(name string, v *MrbValue, result reflect.Value
 		var result float64
 		set = reflect.Indirect(reflect.New(reflect.TypeOf(result)))
 	case TypeString:
+		fallthrough
+	case TypeSymbol:
 		set = reflect.Indirect(reflect.New(reflect.TypeOf("")))
+	case TypeRange:
+		set = reflect.Indirect(reflect.New(rangeType))
+	case TypeProc:
+		// There is nothing to redecode: a proc is stored as a *Proc.
+		set = reflect.ValueOf(v.Proc())
+		redecode = false
 	default:
 		return fmt.Errorf(
 			"%s: cannot decode into interface: %v",
@@ -297,6 +426,38 @@ func (d *decoder) decodePtr(name string, v *MrbValue, result reflect.Value) erro
 	return nil
 }
 
+func (d *decoder) decodeProc(name string, v *MrbValue, result reflect.Value) error {
+	if t := v.Type(); t != TypeProc {
+		return fmt.Errorf("%s: not a proc type (%v)", name, t)
+	}
+
+	result.Set(reflect.ValueOf(*v.Proc()))
+	return nil
+}
+
+func (d *decoder) decodeRange(name string, v *MrbValue, result reflect.Value) error {
+	if t := v.Type(); t != TypeRange {
+		return fmt.Errorf("%s: not a range type (%v)", name, t)
+	}
+
+	begin, end, excl, err := rangeEdges(v)
+	if err != nil {
+		return err
+	}
+
+	var r Range
+	if err := d.decode(name+".begin", begin, reflect.ValueOf(&r.Begin).Elem()); err != nil {
+		return err
+	}
+	if err := d.decode(name+".end", end, reflect.ValueOf(&r.End).Elem()); err != nil {
+		return err
+	}
+	r.Exclusive = excl
+
+	result.Set(reflect.ValueOf(r))
+	return nil
+}
+
 func (d *decoder) decodeSlice(name string, v *MrbValue, result reflect.Value) error {
 	// If we have an interface, then we can address the interface,
 	// but not the slice itself, so get the element but set the interface
@@ -305,9 +466,17 @@ func (d *decoder) decodeSlice(name string, v *MrbValue, result reflect.Value) er
 		result = result.Elem()
 	}
 
-	// Create the slice if it isn't nil
+	// Strings decode into byte slices as raw bytes.
 	resultType := result.Type()
 	resultElemType := resultType.Elem()
+	if t := v.Type(); t == TypeString && resultElemType.Kind() == reflect.Uint8 {
+		set.Set(reflect.ValueOf(v.Bytes()).Convert(resultType))
+		return nil
+	} else if t != TypeArray {
+		return fmt.Errorf("%s: not an array type for slice (%v)", name, t)
+	}
+
+	// Create the slice if it isn't nil
 	if result.IsNil() {
 		resultSliceType := reflect.SliceOf(resultElemType)
 		result = reflect.MakeSlice(
@@ -347,6 +516,8 @@ func (d *decoder) decodeString(name string, v *MrbValue, result reflect.Value) e
 		result.Set(reflect.ValueOf(
 			strconv.FormatInt(int64(v.Fixnum()), 10)).Convert(result.Type()))
 	case TypeString:
+		fallthrough
+	case TypeSymbol:
 		result.Set(reflect.ValueOf(v.String()).Convert(result.Type()))
 	default:
 		return fmt.Errorf("%s: unknown type to string: %v", name, t)
@@ -355,6 +526,69 @@ func (d *decoder) decodeString(name string, v *MrbValue, result reflect.Value) e
 	return nil
 }
 
+func (d *decoder) decodeTime(name string, v *MrbValue, result reflect.Value) error {
+	switch t := v.Type(); t {
+	case TypeString:
+		parsed, err := time.Parse(time.RFC3339Nano, v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		result.Set(reflect.ValueOf(parsed))
+	case TypeData:
+		// We're going to be allocating some garbage, so set the arena
+		// so it is cleared properly.
+		mrb := v.Mrb()
+		defer mrb.ArenaRestore(mrb.ArenaSave())
+
+		// Time only exists if mruby was built with the mruby-time gem.
+		if !mrb.ConstDefined("Time", mrb.ObjectClass()) {
+			return fmt.Errorf("%s: Time class is not available", name)
+		}
+
+		isTime, err := v.Call("is_a?", mrb.Class("Time", nil))
+		if err != nil {
+			return err
+		}
+		if isTime.Type() != TypeTrue {
+			return fmt.Errorf("%s: not a Time object", name)
+		}
+
+		sec, err := v.Call("to_i")
+		if err != nil {
+			return err
+		}
+
+		usec, err := v.Call("usec")
+		if err != nil {
+			return err
+		}
+
+		utc, err := v.Call("utc?")
+		if err != nil {
+			return err
+		}
+
+		var secs int64
+		if sec.Type() == TypeFloat {
+			secs = int64(sec.Float())
+		} else {
+			secs = int64(sec.Fixnum())
+		}
+
+		tm := time.Unix(secs, int64(usec.Fixnum())*int64(time.Microsecond))
+		if utc.Type() == TypeTrue {
+			tm = tm.UTC()
+		}
+
+		result.Set(reflect.ValueOf(tm))
+	default:
+		return fmt.Errorf("%s: unknown type to time: %v", name, t)
+	}
+
+	return nil
+}
+
 func (d *decoder) decodeStruct(name string, v *MrbValue, result reflect.Value) error {
 	var get decodeStructGetter
 
@@ -509,3 +743,24 @@ func decodeStructObjectMethods(mrb *Mrb, v *MrbValue) decodeStructGetter {
 		return v.Call(key)
 	}
 }
+
+// rangeEdges returns the begin and end values of a Ruby range and whether
+// the range excludes its end.
+func rangeEdges(v *MrbValue) (*MrbValue, *MrbValue, bool, error) {
+	begin, err := v.Call("begin")
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	end, err := v.Call("end")
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	excl, err := v.Call("exclude_end?")
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return begin, end, excl.Type() == TypeTrue, nil
+}
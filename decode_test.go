@@ -3,6 +3,7 @@ package mruby
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestDecode(t *testing.T) {
@@ -10,20 +11,40 @@ func TestDecode(t *testing.T) {
 		Foo string
 	}
 
+	var outArray [2]int
+	var outArrayString [3]string
 	var outBool bool
+	var outBytes []byte
 	var outFloat64 float64
 	var outInt int
+	var outInt8 int8
+	var outInt64 int64
 	var outMap, outMap2 map[string]string
 	var outPtrInt *int
+	var outRange Range
 	var outSlice []string
 	var outString string
 	var outStructString structString
+	var outTime time.Time
 
 	cases := []struct {
 		Input    string
 		Output   interface{}
 		Expected interface{}
 	}{
+		// Array
+		{
+			"1..5",
+			&outArray,
+			[2]int{1, 5},
+		},
+
+		{
+			`["a", "b", "c"]`,
+			&outArrayString,
+			[3]string{"a", "b", "c"},
+		},
+
 		// Booleans
 		{
 			"true",
@@ -37,11 +58,18 @@ func TestDecode(t *testing.T) {
 			false,
 		},
 
+		// Bytes
+		{
+			`"foo\0bar\xff"`,
+			&outBytes,
+			[]byte("foo\x00bar\xff"),
+		},
+
 		// Float
 		{
 			"1.2",
 			&outFloat64,
-			float64(1.2000000476837158),
+			float64(1.2),
 		},
 
 		// Int
@@ -57,6 +85,18 @@ func TestDecode(t *testing.T) {
 			int(32),
 		},
 
+		{
+			`-12`,
+			&outInt8,
+			int8(-12),
+		},
+
+		{
+			`"9000000000"`,
+			&outInt64,
+			int64(9000000000),
+		},
+
 		// Map
 		{
 			`{"foo" => "bar"}`,
@@ -84,6 +124,13 @@ func TestDecode(t *testing.T) {
 			32,
 		},
 
+		// Range
+		{
+			`1...5`,
+			&outRange,
+			Range{Begin: 1, End: 5, Exclusive: true},
+		},
+
 		// String
 		{
 			`32`,
@@ -91,6 +138,12 @@ func TestDecode(t *testing.T) {
 			"32",
 		},
 
+		{
+			`:foo`,
+			&outString,
+			"foo",
+		},
+
 		{
 			`"32"`,
 			&outString,
@@ -110,6 +163,20 @@ func TestDecode(t *testing.T) {
 			&outStructString,
 			structString{Foo: "bar"},
 		},
+
+		// Struct from Hash with a missing key
+		{
+			`{}`,
+			&outStructString,
+			structString{},
+		},
+
+		// Time
+		{
+			`"2016-01-02T15:04:05Z"`,
+			&outTime,
+			time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC),
+		},
 	}
 
 	for _, tc := range cases {
@@ -156,7 +223,7 @@ func TestDecodeInterface(t *testing.T) {
 		// Float
 		{
 			"1.2",
-			float64(1.2000000476837158),
+			float64(1.2),
 		},
 
 		// Int
@@ -187,6 +254,29 @@ func TestDecodeInterface(t *testing.T) {
 			`"32"`,
 			"32",
 		},
+
+		// Symbol
+		{
+			`:foo`,
+			"foo",
+		},
+
+		// Nil
+		{
+			`nil`,
+			nil,
+		},
+
+		{
+			`[1, nil]`,
+			[]interface{}{1, nil},
+		},
+
+		// Range
+		{
+			`1..2`,
+			Range{Begin: 1, End: 2},
+		},
 	}
 
 	for _, tc := range cases {
@@ -210,6 +300,138 @@ func TestDecodeInterface(t *testing.T) {
 	}
 }
 
+func TestDecodeArray_length(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`[1, 2, 3]`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var out [2]int
+	if err := Decode(&out, value); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestDecodeInt_overflow(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`300`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var out int8
+	if err := Decode(&out, value); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestDecodeTime(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	if !mrb.ConstDefined("Time", mrb.ObjectClass()) {
+		t.Skip("mruby-time is not built in")
+	}
+
+	value, err := mrb.LoadString(`Time.at(1451747045, 500).utc`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var out time.Time
+	if err := Decode(&out, value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := time.Date(2016, 1, 2, 15, 4, 5, 500000, time.UTC)
+	if !out.Equal(expected) {
+		t.Fatalf("bad: %s", out)
+	}
+}
+
+func TestDecodeNil(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`nil`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	outInt := 42
+	if err := Decode(&outInt, value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if outInt != 0 {
+		t.Fatalf("bad: %d", outInt)
+	}
+
+	outPtr := &outInt
+	if err := Decode(&outPtr, value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if outPtr != nil {
+		t.Fatalf("bad: %#v", outPtr)
+	}
+}
+
+func TestDecodeProc(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`Proc.new { |a, b| a + b }`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var proc *Proc
+	if err := Decode(&proc, value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := proc.Call(Int(12), Int(30))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Fixnum() != 42 {
+		t.Fatalf("bad: %s", result)
+	}
+
+	var out interface{}
+	if err := Decode(&out, value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, ok := out.(*Proc); !ok {
+		t.Fatalf("bad: %#v", out)
+	}
+
+	value, err = mrb.LoadString(`{"fn" => Proc.new { |a| a * 2 }}`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var m map[string]interface{}
+	if err := Decode(&m, value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	fn, ok := m["fn"].(*Proc)
+	if !ok {
+		t.Fatalf("bad: %#v", m)
+	}
+	result, err = fn.Call(Int(21))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Fixnum() != 42 {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
 const testDecodeObjectMethods = `
 class Foo
 	def foo
@@ -2,7 +2,6 @@ package mruby
 
 import (
 	"fmt"
-	"sync"
 	"unsafe"
 )
 
@@ -18,69 +17,43 @@ import "C"
 // The second return value is an exception, if any. This will be raised.
 type Func func(m *Mrb, self *MrbValue) (Value, Value)
 
-type classMethodMap map[*C.struct_RClass]*methods
-type methodMap map[C.mrb_sym]Func
-type stateMethodMap map[*C.mrb_state]*classMethods
-
-type classMethods struct {
-	Map   classMethodMap
-	Mutex *sync.Mutex
-}
-
-type methods struct {
-	Map   methodMap
-	Mutex *sync.Mutex
-}
-
-type stateMethods struct {
-	Map   stateMethodMap
-	Mutex *sync.Mutex
-}
-
-// stateMethodTable is the lookup table for methods that we define in Go and
-// expose in Ruby. This is cleaned up by Mrb.Close.
-var stateMethodTable *stateMethods
-
-func init() {
-	stateMethodTable = &stateMethods{
-		Mutex: new(sync.Mutex),
-		Map:   make(stateMethodMap),
-	}
+// methodFunc is a Go function exposed to Ruby along with the ArgSpec
+// and Keywords it was defined with.
+type methodFunc struct {
+	f        Func
+	spec     ArgSpec
+	keywords Keywords
 }
 
 //export goMRBFuncCall
 func goMRBFuncCall(s *C.mrb_state, v C.mrb_value) C.mrb_value {
-	// Lookup the classes that we've registered methods for in this state
-	stateMethodTable.Mutex.Lock()
-	classTable := stateMethodTable.Map[s]
-	stateMethodTable.Mutex.Unlock()
-	if classTable == nil {
+	// Lookup the Mrb that owns this state
+	value, ok := stateRegistry.Load(s)
+	if !ok {
 		panic(fmt.Sprintf("func call from unknown state: %p", s))
 	}
+	mrb := value.(*Mrb)
 
-	// Get the call info, which we use to lookup the proc
-	ci := s.c.ci
-
-	// Lookup the class itself
-	classTable.Mutex.Lock()
-	methodTable := classTable.Map[ci.proc.target_class]
-	classTable.Mutex.Unlock()
-	if methodTable == nil {
-		panic("func call on unknown class")
-	}
+	mrb.funcDepth++
+	defer func() { mrb.funcDepth-- }()
 
-	// Lookup the method
-	methodTable.Mutex.Lock()
-	f := methodTable.Map[ci.mid]
-	methodTable.Mutex.Unlock()
-	if f == nil {
+	// The proc being called knows the id of its function
+	id := int(C._go_mrb_func_id(s))
+	if id < 0 || id >= len(mrb.funcs) {
 		panic("func call on unknown method")
 	}
+	method := mrb.funcs[id]
+
+	// Functions that declare keywords get their keywords checked first
+	if method.hasKeywords() {
+		if _, exc := mrb.getArgsSpec(method); exc != nil {
+			s.exc = C._go_mrb_getobj(exc.value)
+			return mrb.NilValue().value
+		}
+	}
 
 	// Call the method to get our *Value
-	// TODO(mitchellh): reuse the Mrb instead of allocating every time
-	mrb := &Mrb{s}
-	result, exc := f(mrb, newValue(s, v))
+	result, exc := method.f(mrb, newValue(s, v))
 
 	if result == nil {
 		result = mrb.NilValue()
@@ -94,28 +67,42 @@ func goMRBFuncCall(s *C.mrb_state, v C.mrb_value) C.mrb_value {
 	return result.MrbValue(mrb).value
 }
 
-func insertMethod(s *C.mrb_state, c *C.struct_RClass, n string, f Func) {
-	stateMethodTable.Mutex.Lock()
-	classLookup := stateMethodTable.Map[s]
-	if classLookup == nil {
-		classLookup = &classMethods{Map: make(classMethodMap), Mutex: new(sync.Mutex)}
-		stateMethodTable.Map[s] = classLookup
+// defineMethod defines the method n on class c, implemented by the Go
+// function fn.
+func defineMethod(m *Mrb, c *C.struct_RClass, n string, fn methodFunc) {
+	s := m.state
+
+	var id int
+	if n := len(m.freeFuncs); n > 0 {
+		id = m.freeFuncs[n-1]
+		m.freeFuncs = m.freeFuncs[:n-1]
+		m.funcs[id] = fn
+	} else {
+		id = len(m.funcs)
+		m.funcs = append(m.funcs, fn)
 	}
-	stateMethodTable.Mutex.Unlock()
-
-	classLookup.Mutex.Lock()
-	methodLookup := classLookup.Map[c]
-	if methodLookup == nil {
-		methodLookup = &methods{Map: make(methodMap), Mutex: new(sync.Mutex)}
-		classLookup.Map[c] = methodLookup
-	}
-	classLookup.Mutex.Unlock()
 
 	cs := C.CString(n)
 	defer C.free(unsafe.Pointer(cs))
 
-	sym := C.mrb_intern_cstr(s, cs)
-	methodLookup.Mutex.Lock()
-	methodLookup.Map[sym] = f
-	methodLookup.Mutex.Unlock()
+	C.mrb_define_method_raw(
+		s, c, C.mrb_intern_cstr(s, cs), C._go_mrb_func_proc(s, C.mrb_int(id)))
+}
+
+//export goFuncFree
+func goFuncFree(s *C.mrb_state, p unsafe.Pointer) {
+	m, ok := freeingMrb(s)
+	if !ok {
+		return
+	}
+
+	// The proc that called the function is gone, along with every method
+	// that was defined with it or aliased to it, so the slot can be reused.
+	id := int(uintptr(p)) - 1
+	if id < 0 || id >= len(m.funcs) {
+		return
+	}
+
+	m.funcs[id] = methodFunc{}
+	m.freeFuncs = append(m.freeFuncs, id)
 }
@@ -21,3 +21,50 @@ func testCallbackException(m *Mrb, self *MrbValue) (Value, Value) {
 	v := e.(*Exception)
 	return nil, v.MrbValue
 }
+
+func benchmarkFuncCallVM(b *testing.B) (*Mrb, *MrbValue) {
+	mrb := NewMrb()
+
+	add := func(m *Mrb, self *MrbValue) (Value, Value) {
+		args := m.GetArgs()
+		return Int(args[0].Fixnum() + args[1].Fixnum()), nil
+	}
+
+	class := mrb.DefineClass("Hello", nil)
+	class.DefineClassMethod("add", add, ArgsReq(2))
+	return mrb, class.MrbValue(mrb)
+}
+
+func BenchmarkFuncCall(b *testing.B) {
+	mrb, class := benchmarkFuncCallVM(b)
+	defer mrb.Close()
+
+	ai := mrb.ArenaSave()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := class.Call("add", Int(1), Int(2)); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+
+		mrb.ArenaRestore(ai)
+	}
+}
+
+// BenchmarkFuncCallParallel calls Go functions from many VMs at once.
+// Since each VM has its own state, this should scale with GOMAXPROCS.
+func BenchmarkFuncCallParallel(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		mrb, class := benchmarkFuncCallVM(b)
+		defer mrb.Close()
+
+		ai := mrb.ArenaSave()
+		for pb.Next() {
+			if _, err := class.Call("add", Int(1), Int(2)); err != nil {
+				b.Errorf("err: %s", err)
+				return
+			}
+
+			mrb.ArenaRestore(ai)
+		}
+	})
+}
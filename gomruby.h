@@ -13,6 +13,8 @@
 #include <mruby/array.h>
 #include <mruby/class.h>
 #include <mruby/compile.h>
+#include <mruby/data.h>
+#include <mruby/debug.h>
 #include <mruby/error.h>
 #include <mruby/irep.h>
 #include <mruby/gc.h>
@@ -36,6 +38,8 @@
 // This is declard in func.go and is a way for us to call back into
 // Go to execute a method.
 extern mrb_value goMRBFuncCall(mrb_state*, mrb_value);
+extern void goStructFree(mrb_state*, void*);
+extern void goFuncFree(mrb_state*, void*);
 
 // This method is used as a way to get a valid mrb_func_t that actually
 // just calls back into Go.
@@ -43,6 +47,56 @@ static inline mrb_func_t _go_mrb_func_t() {
     return &goMRBFuncCall;
 }
 
+#define GOMRUBY_FUNC_TYPE "GoFunc"
+
+static void _go_func_free(mrb_state *mrb, void *p) {
+  goFuncFree(mrb, p);
+}
+
+// This creates a proc that calls back into Go. The id of the Go function
+// is stored in the environment of the proc itself, so the proc finds its
+// function no matter how the method was reached: subclasses, aliases,
+// included modules, send, etc.
+//
+// The id is wrapped in a data object that only the proc refers to, so
+// goFuncFree releases the function once the proc is garbage collected.
+// The id is stored plus one so that the pointer is never NULL.
+static inline struct RProc *_go_mrb_func_proc(mrb_state *mrb, mrb_int id) {
+  static const struct mrb_data_type type = { GOMRUBY_FUNC_TYPE, _go_func_free };
+
+  mrb_value env = mrb_obj_value(mrb_data_object_alloc(
+        mrb, mrb->object_class, (void *)(intptr_t)(id + 1), &type));
+  return mrb_proc_new_cfunc_with_env(mrb, &goMRBFuncCall, 1, &env);
+}
+
+// This returns the id of the Go function that the proc calls, or -1 if
+// the proc wasn't created by _go_mrb_func_proc.
+static inline mrb_int _go_mrb_proc_func_id(struct RProc *p) {
+  mrb_value id;
+
+  if (p == NULL || !MRB_PROC_CFUNC_P(p) || p->body.func != &goMRBFuncCall) {
+    return -1;
+  }
+
+  if (p->env == NULL || MRB_ENV_STACK_LEN(p->env) < 1) {
+    return -1;
+  }
+
+  id = p->env->stack[0];
+  if (mrb_type(id) != MRB_TT_DATA || DATA_TYPE(id) == NULL ||
+      strcmp(DATA_TYPE(id)->struct_name, GOMRUBY_FUNC_TYPE) != 0) {
+    return -1;
+  }
+
+  return (mrb_int)(intptr_t)DATA_PTR(id) - 1;
+}
+
+// This returns the id of the Go function for the currently executing
+// proc, or -1 if the proc wasn't created by _go_mrb_func_proc.
+static inline mrb_int _go_mrb_func_id(mrb_state *mrb) {
+  return _go_mrb_proc_func_id(mrb->c->ci->proc);
+}
+
 //-------------------------------------------------------------------
 // Helpers to deal with calling into Ruby (C)
 //-------------------------------------------------------------------
@@ -88,32 +142,259 @@ static mrb_value _go_mrb_call(mrb_state *mrb, mrb_value b, mrb_sym method, mrb_i
   GOMRUBY_EXC_PROTECT_END
 }
 
-//-------------------------------------------------------------------
-// Helpers to deal with getting arguments
-//-------------------------------------------------------------------
-// This is declard in args.go
-extern void goGetArgAppend(mrb_value);
+// This is _go_mrb_call for up to three arguments and no block. The
+// arguments are passed by value so that Go doesn't have to put an
+// argument list on the heap to get a pointer to it.
+static mrb_value _go_mrb_call3(mrb_state *mrb, mrb_value self, mrb_sym method, mrb_int argc, mrb_value a0, mrb_value a1, mrb_value a2) {
+  mrb_value argv[3];
 
-// This gets all arguments given to a function call and adds them to
-// the accumulator in Go.
-static inline int _go_mrb_get_args_all(mrb_state *s) {
-  mrb_value *argv;
-  mrb_value block;
-  mrb_bool append;
-  int argc, i;
+  argv[0] = a0;
+  argv[1] = a1;
+  argv[2] = a2;
+
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_funcall_argv(mrb, self, method, argc, argv);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_noop(mrb_state *mrb, mrb_value self) {
+  return mrb_nil_value();
+}
+
+// mrb_yield_with_class only makes room on the stack for the registers
+// of a Ruby proc, but copies all of the arguments onto it, while
+// mrb_funcall_with_block makes room for both. If there isn't enough
+// room for the arguments, this yields to a C function with them first,
+// which makes room for them the way mrb_funcall_with_block does.
+static void _go_mrb_method_stack(mrb_state *mrb, mrb_value self, struct RProc *p, struct RClass *owner, mrb_int argc, const mrb_value *argv) {
+  mrb_value *end = mrb->c->stack + mrb->c->ci->nregs + argc + 2;
+
+  if (MRB_PROC_CFUNC_P(p) || end < mrb->c->stend) {
+    return;
+  }
+
+  mrb_yield_with_class(mrb, mrb_obj_value(mrb_proc_new_cfunc(mrb, _go_mrb_noop)), argc, argv, self, owner);
+}
+
+// This calls a method body found with _go_mrb_method_search, skipping
+// the method lookup that _go_mrb_call does. mrb_yield_with_class runs
+// the proc under the name of the calling method, so the name is swapped
+// in for the duration of the call to keep super and __method__ working.
+// It also doesn't clean up after a C function that raises, which is
+// done here the way mrb_funcall_with_block does it, and doesn't make
+// room for the arguments, which _go_mrb_method_stack does.
+static mrb_value _go_mrb_method_call(mrb_state *mrb, mrb_value self, mrb_sym mid, struct RProc *p, struct RClass *owner, mrb_int argc, const mrb_value *argv) {
+  struct mrb_jmpbuf *prev_jmp = mrb->jmp;
+  struct mrb_jmpbuf c_jmp;
+  ptrdiff_t nth_ci = mrb->c->ci - mrb->c->cibase;
+  mrb_sym prev_mid = mrb->c->ci->mid;
+  mrb_value result = mrb_nil_value();
+
+  MRB_TRY(&c_jmp) {
+    mrb->jmp = &c_jmp;
+    _go_mrb_method_stack(mrb, self, p, owner, argc, argv);
+    mrb->c->ci->mid = mid;
+    result = mrb_yield_with_class(mrb, mrb_obj_value(p), argc, argv, self, owner);
+    mrb->jmp = prev_jmp;
+  } MRB_CATCH(&c_jmp) {
+    mrb->jmp = prev_jmp;
+    while (mrb->c->ci - mrb->c->cibase > nth_ci) {
+      mrb->c->stack = mrb->c->ci->stackent;
+      mrb->c->ci--;
+    }
+    result = mrb_nil_value();
+  } MRB_END_EXC(&c_jmp);
+
+  mrb->c->ci->mid = prev_mid;
+  mrb_gc_protect(mrb, result);
+  return result;
+}
+
+// This finds the method mid for instances of c. The class or module that
+// defines the method is stored in owner. This returns NULL if there is
+// no such method, or if it was undefined.
+static inline struct RProc *_go_mrb_method_search(mrb_state *mrb, struct RClass *c, mrb_sym mid, struct RClass **owner) {
+  struct RClass *found = c;
+  struct RProc *p = mrb_method_search_vm(mrb, &found, mid);
+
+  if (p == NULL) {
+    return NULL;
+  }
+
+  // Included and prepended modules are found through internal classes
+  // that point at the real module.
+  if (found->tt == MRB_TT_ICLASS) {
+    found = found->c;
+  }
+
+  *owner = found;
+  return p;
+}
 
-  mrb_get_args(s, "*&?", &argv, &argc, &block, &append);
+// This returns the file and line where a Ruby method body was defined.
+// The file is NULL if it isn't known, such as for C functions or for
+// code compiled without a filename.
+static inline const char *_go_mrb_proc_source(struct RProc *p, int32_t *line) {
+  const char *file;
 
-  for (i = 0; i < argc; i++) {
-    goGetArgAppend(argv[i]);
+  if (MRB_PROC_CFUNC_P(p) || p->body.irep == NULL) {
+    return NULL;
   }
 
-  if (append == FALSE || mrb_type(block) == MRB_TT_FALSE) {
-    return argc;
+  file = mrb_debug_get_filename(p->body.irep, 0);
+  if (file == NULL) {
+    return NULL;
   }
 
-  argc++;
-  goGetArgAppend(block);
+  *line = mrb_debug_get_line(p->body.irep, 0);
+  return file;
+}
+
+static mrb_value _go_mrb_const_get(mrb_state *mrb, mrb_value mod, mrb_sym sym) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_const_get(mrb, mod, sym);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_check_type(mrb_state *mrb, mrb_value v, enum mrb_vtype t) {
+  GOMRUBY_EXC_PROTECT_START
+  mrb_check_type(mrb, v, t);
+  result = v;
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_define_class_under(mrb_state *mrb, struct RClass *outer, const char *name, struct RClass *super) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_obj_value(mrb_define_class_under(mrb, outer, name, super));
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_define_module_under(mrb_state *mrb, struct RClass *outer, const char *name) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_obj_value(mrb_define_module_under(mrb, outer, name));
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_run(mrb_state *mrb, struct RProc *proc, mrb_value self) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_run(mrb, proc, self);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_context_run(mrb_state *mrb, struct RProc *proc, mrb_value self, int *stack_keep) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_context_run(mrb, proc, self, *stack_keep);
+  *stack_keep = proc->body.irep->nlocals;
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_obj_new(mrb_state *mrb, struct RClass *c, mrb_int argc, const mrb_value *argv) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_obj_new(mrb, c, argc, argv);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_class_get_under(mrb_state *mrb, struct RClass *outer, const char *name) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_obj_value(mrb_class_get_under(mrb, outer, name));
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_module_get(mrb_state *mrb, const char *name) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_obj_value(mrb_module_get(mrb, name));
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_singleton_class(mrb_state *mrb, mrb_value v) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_singleton_class(mrb, v);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_const_defined(mrb_state *mrb, mrb_value mod, mrb_sym sym) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_bool_value(mrb_const_defined(mrb, mod, sym));
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_iv_set(mrb_state *mrb, mrb_value self, mrb_sym sym, mrb_value v) {
+  GOMRUBY_EXC_PROTECT_START
+  mrb_iv_set(mrb, self, sym, v);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_hash_get(mrb_state *mrb, mrb_value hash, mrb_value key) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_hash_get(mrb, hash, key);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_hash_set(mrb_state *mrb, mrb_value hash, mrb_value key, mrb_value val) {
+  GOMRUBY_EXC_PROTECT_START
+  mrb_hash_set(mrb, hash, key, val);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_hash_delete_key(mrb_state *mrb, mrb_value hash, mrb_value key) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_hash_delete_key(mrb, hash, key);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_cv_get(mrb_state *mrb, mrb_value mod, mrb_sym sym) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_cv_get(mrb, mod, sym);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_cv_set(mrb_state *mrb, mrb_value mod, mrb_sym sym, mrb_value v) {
+  GOMRUBY_EXC_PROTECT_START
+  mrb_cv_set(mrb, mod, sym, v);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+static mrb_value _go_mrb_obj_to_s(mrb_state *mrb, mrb_value v) {
+  GOMRUBY_EXC_PROTECT_START
+  result = mrb_obj_as_string(mrb, v);
+  GOMRUBY_EXC_PROTECT_END
+}
+
+// This converts a value to a string with to_s. If to_s raises, the
+// exception is discarded and the default Object#to_s representation is
+// used instead, so converting a value to a string never fails. Any
+// exception that was already pending (such as the one being converted
+// into a Go error) is kept.
+static mrb_value _go_mrb_obj_as_string(mrb_state *mrb, mrb_value v) {
+  struct RObject *exc = mrb->exc;
+  struct mrb_jmpbuf *prev_jmp = mrb->jmp;
+  struct mrb_jmpbuf c_jmp;
+  mrb_value result = mrb_nil_value();
+
+  mrb->exc = NULL;
+  MRB_TRY(&c_jmp) {
+    mrb->jmp = &c_jmp;
+    result = mrb_obj_as_string(mrb, v);
+    mrb->jmp = prev_jmp;
+  } MRB_CATCH(&c_jmp) {
+    mrb->jmp = prev_jmp;
+    result = mrb_any_to_s(mrb, v);
+  } MRB_END_EXC(&c_jmp);
+  mrb->exc = exc;
+
+  return result;
+}
+
+//-------------------------------------------------------------------
+// Helpers to deal with getting arguments
+//-------------------------------------------------------------------
+// This gets all arguments given to a function call. argv is set to point
+// at the arguments on the VM stack, so Go must copy them before the
+// function call returns. block is set to nil if no block was given.
+static inline mrb_int _go_mrb_get_args_all(mrb_state *s, mrb_value **argv, mrb_value *block) {
+  mrb_int argc;
+
+  mrb_get_args(s, "*&", argv, &argc, block);
 
   return argc;
 }
@@ -148,6 +429,10 @@ static inline mrb_aspec _go_MRB_ARGS_ARG(int r, int o) {
   return MRB_ARGS_ARG(r, o);
 }
 
+static inline mrb_aspec _go_MRB_ARGS_KEY(int n, int rest) {
+  return MRB_ARGS_KEY(n, rest);
+}
+
 static inline mrb_aspec _go_MRB_ARGS_BLOCK() {
   return MRB_ARGS_BLOCK();
 }
@@ -164,14 +449,30 @@ static inline mrb_aspec _go_MRB_ARGS_REQ(int n) {
   return MRB_ARGS_REQ(n);
 }
 
-static inline float _go_mrb_float(mrb_value o) {
+static inline mrb_float _go_mrb_float(mrb_value o) {
   return mrb_float(o);
 }
 
-static inline int _go_mrb_fixnum(mrb_value o) {
+static inline mrb_int _go_mrb_fixnum(mrb_value o) {
   return mrb_fixnum(o);
 }
 
+static inline mrb_int _go_MRB_INT_MAX() {
+  return MRB_INT_MAX;
+}
+
+static inline mrb_int _go_MRB_INT_MIN() {
+  return MRB_INT_MIN;
+}
+
+static inline const char *_go_RSTRING_PTR(mrb_value s) {
+  return RSTRING_PTR(s);
+}
+
+static inline mrb_int _go_RSTRING_LEN(mrb_value s) {
+  return RSTRING_LEN(s);
+}
+
 static inline struct RBasic *_go_mrb_basic_ptr(mrb_value o) {
   return mrb_basic_ptr(o);
 }
@@ -188,10 +489,29 @@ static inline mrb_bool _go_mrb_nil_p(mrb_value o) {
   return mrb_nil_p(o);
 }
 
+static inline int _go_mrb_proc_lambda(mrb_value v) {
+  return MRB_PROC_STRICT_P(mrb_proc_ptr(v));
+}
+
+static inline mrb_bool _go_mrb_test(mrb_value o) {
+  return mrb_test(o);
+}
+
 static inline struct RClass *_go_mrb_class_ptr(mrb_value o) {
   return mrb_class_ptr(o);
 }
 
+// This returns the superclass of a class, skipping the internal classes
+// that mruby uses for included modules and singletons. This returns
+// NULL for BasicObject and for modules.
+static inline struct RClass *_go_mrb_superclass(struct RClass *c) {
+  if (c->tt != MRB_TT_CLASS || c->super == NULL) {
+    return NULL;
+  }
+
+  return mrb_class_real(c->super);
+}
+
 static inline void _go_set_gc(mrb_state *m, int val) {
   mrb_gc *gc = &m->gc;
   gc->disabled = val;
@@ -242,19 +562,10 @@ static inline void _go_mrb_context_set_capture_errors(struct mrbc_context *ctx,
   }
 }
 
-static inline mrb_value _go_mrb_context_run(mrb_state *m, struct RProc *proc, mrb_value self, int *stack_keep) {
-  mrb_value result = mrb_context_run(m, proc, self, *stack_keep);
-  *stack_keep = proc->body.irep->nlocals;
-  return result;
-}
-
 static inline struct RObject* _go_mrb_getobj(mrb_value v) {
   return mrb_obj_ptr(v);
 }
 
-static inline void _go_mrb_iv_set(mrb_state *m, mrb_value self, mrb_sym sym, mrb_value v) {
-  mrb_iv_set(m, self, sym, v);
-}
 
 static inline mrb_value _go_mrb_iv_get(mrb_state *m, mrb_value self, mrb_sym sym) {
   return mrb_iv_get(m, self, sym);
@@ -268,4 +579,55 @@ static inline mrb_value _go_mrb_gv_get(mrb_state *m, mrb_sym sym) {
   return mrb_gv_get(m, sym);
 }
 
+//-------------------------------------------------------------------
+// Helpers for Go structs wrapped in Ruby objects
+//-------------------------------------------------------------------
+// Wrapped objects are recognized by the name of their data type rather
+// than the address of the mrb_data_type, since every Go file that
+// includes this header gets its own copy of the static data.
+#define GOMRUBY_STRUCT_TYPE "GoStruct"
+
+static void _go_struct_free(mrb_state *mrb, void *p) {
+  goStructFree(mrb, p);
+}
+
+// This makes instances of the class RData objects, so they can hold the
+// id of a Go struct.
+static inline void _go_mrb_set_instance_data(struct RClass *c) {
+  MRB_SET_INSTANCE_TT(c, MRB_TT_DATA);
+}
+
+// This stores the id of a Go struct in an object. The id is released
+// again by goStructFree when the object is garbage collected.
+static inline void _go_mrb_struct_set(mrb_value self, mrb_int id) {
+  static const struct mrb_data_type type = { GOMRUBY_STRUCT_TYPE, _go_struct_free };
+
+  DATA_TYPE(self) = &type;
+  DATA_PTR(self) = (void *)(intptr_t)id;
+}
+
+static inline mrb_value _go_mrb_struct_wrap(mrb_state *mrb, struct RClass *c, mrb_int id) {
+  mrb_value self = mrb_obj_value(mrb_data_object_alloc(mrb, c, NULL, NULL));
+  _go_mrb_struct_set(self, id);
+  return self;
+}
+
+// This returns the id of the Go struct wrapped by the object, or 0 if
+// the object doesn't wrap one.
+static inline mrb_int _go_mrb_struct_id(mrb_value v) {
+  if (mrb_type(v) != MRB_TT_DATA || DATA_TYPE(v) == NULL ||
+      strcmp(DATA_TYPE(v)->struct_name, GOMRUBY_STRUCT_TYPE) != 0) {
+    return 0;
+  }
+
+  return (mrb_int)(intptr_t)DATA_PTR(v);
+}
+
+// This tells whether a Go struct can be stored in the object: it must be
+// an RData object that holds no other kind of data.
+static inline mrb_bool _go_mrb_struct_holder(mrb_value v) {
+  return mrb_type(v) == MRB_TT_DATA &&
+    (DATA_TYPE(v) == NULL || _go_mrb_struct_id(v) != 0);
+}
+
 #endif
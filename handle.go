@@ -0,0 +1,118 @@
+package mruby
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// #include "gomruby.h"
+import "C"
+
+// handlesName is the hidden root (see setHiddenRoot) that holds the
+// array of pinned values.
+const handlesName = "__gomruby_handles__"
+
+// Handle keeps a value from being garbage collected until it is
+// released. Get one with MrbValue.Pin.
+//
+// Unlike GCProtect, which only protects a value until the arena is
+// restored, a Handle keeps its value alive across any number of calls
+// into the VM, so it is how values kept in Go between calls, such as
+// callbacks or cached objects, should be held on to. A Handle can't be
+// used after its VM is closed.
+type Handle struct {
+	mrb   *Mrb
+	slot  int
+	value C.mrb_value
+
+	// site is where the handle was pinned, for the leak report.
+	site string
+}
+
+// Pin keeps the value alive until Release is called on the returned
+// Handle. Every Handle should be released once it is no longer needed;
+// handles still pinned when the VM is closed are reported to
+// Options.HandleLeaks.
+func (v *MrbValue) Pin() *Handle {
+	defer enterState(v.state)()
+
+	return lookupMrb(v.state).pin(v.value, pinSite(1))
+}
+
+// pin keeps the value alive until the returned handle is released. site
+// is where the handle was asked for, for the leak report.
+func (m *Mrb) pin(value C.mrb_value, site string) *Handle {
+	h := &Handle{mrb: m, value: value, site: site}
+
+	if n := len(m.freeHandles); n > 0 {
+		h.slot = m.freeHandles[n-1]
+		m.freeHandles = m.freeHandles[:n-1]
+		m.pinned[h.slot] = h
+	} else {
+		h.slot = len(m.pinned)
+		m.pinned = append(m.pinned, h)
+	}
+
+	C.mrb_ary_set(m.state, m.handles.value, C.mrb_int(h.slot), value)
+	return h
+}
+
+// pinSite returns the file and line skip frames above the caller of
+// pinSite.
+func pinSite(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+// Value returns the pinned value, or nil if the handle was released.
+func (h *Handle) Value() *MrbValue {
+	if h.mrb == nil {
+		return nil
+	}
+
+	return newValue(h.mrb.state, h.value)
+}
+
+// Release lets the value be garbage collected again, unless something
+// else still refers to it. Releasing a handle more than once is fine.
+func (h *Handle) Release() {
+	m := h.mrb
+	if m == nil {
+		return
+	}
+	h.mrb = nil
+
+	// The VM may have been closed already
+	if m.pinned == nil {
+		return
+	}
+
+	defer enterState(m.state)()
+
+	C.mrb_ary_set(m.state, m.handles.value, C.mrb_int(h.slot), C.mrb_nil_value())
+	m.pinned[h.slot] = nil
+	m.freeHandles = append(m.freeHandles, h.slot)
+}
+
+// reportHandleLeaks passes the handles that are still pinned to
+// Options.HandleLeaks, if it is set.
+func (m *Mrb) reportHandleLeaks() {
+	if m.options.HandleLeaks == nil {
+		return
+	}
+
+	var sites []string
+	for _, h := range m.pinned {
+		if h != nil {
+			sites = append(sites, h.site)
+		}
+	}
+
+	if len(sites) > 0 {
+		m.options.HandleLeaks(sites)
+	}
+}
@@ -0,0 +1,75 @@
+package mruby
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMrbValuePin(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	ai := mrb.ArenaSave()
+	value, err := mrb.LoadString(`Object.new`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	handle := value.Pin()
+	mrb.ArenaRestore(ai)
+
+	// The value survives leaving the arena
+	mrb.FullGC()
+	result, err := handle.Value().Call("nil?")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Truthy() {
+		t.Fatalf("bad: %s", result)
+	}
+
+	handle.Release()
+	handle.Release()
+	if handle.Value() != nil {
+		t.Fatal("released handle should have no value")
+	}
+
+	// Once released, the value can be collected
+	mrb.FullGC()
+	if !value.IsDead() {
+		t.Fatal("released value should be collected")
+	}
+
+	// Released slots are reused
+	first := mrb.FixnumValue(1).Pin()
+	first.Release()
+	second := mrb.FixnumValue(2).Pin()
+	defer second.Release()
+	if second.Value().Fixnum() != 2 {
+		t.Fatalf("bad: %s", second.Value())
+	}
+}
+
+func TestMrbClose_handleLeaks(t *testing.T) {
+	var leaks []string
+	mrb := NewMrbWithOptions(Options{
+		HandleLeaks: func(sites []string) {
+			leaks = sites
+		},
+	})
+
+	leaked := mrb.StringValue("leaked").Pin()
+	released := mrb.StringValue("released").Pin()
+	released.Release()
+
+	mrb.Close()
+
+	if len(leaks) != 1 {
+		t.Fatalf("bad: %#v", leaks)
+	}
+	if !strings.Contains(leaks[0], "handle_test.go:") {
+		t.Fatalf("bad: %s", leaks[0])
+	}
+
+	// Releasing after Close is a no-op
+	leaked.Release()
+}
@@ -13,8 +13,13 @@ type Hash struct {
 // Delete deletes a key from the hash, returning its existing value,
 // or nil if there wasn't a value.
 func (h *Hash) Delete(key Value) (*MrbValue, error) {
-	keyVal := key.MrbValue(&Mrb{h.state}).value
-	result := C.mrb_hash_delete_key(h.state, h.value, keyVal)
+	defer enterState(h.state)()
+
+	keyVal := key.MrbValue(lookupMrb(h.state)).value
+	result := C._go_mrb_hash_delete_key(h.state, h.value, keyVal)
+	if err := checkException(h.state); err != nil {
+		return nil, err
+	}
 
 	val := newValue(h.state, result)
 	if val.Type() == TypeNil {
@@ -26,23 +31,33 @@ func (h *Hash) Delete(key Value) (*MrbValue, error) {
 
 // Get reads a value from the hash.
 func (h *Hash) Get(key Value) (*MrbValue, error) {
-	keyVal := key.MrbValue(&Mrb{h.state}).value
-	result := C.mrb_hash_get(h.state, h.value, keyVal)
+	defer enterState(h.state)()
+
+	keyVal := key.MrbValue(lookupMrb(h.state)).value
+	result := C._go_mrb_hash_get(h.state, h.value, keyVal)
+	if err := checkException(h.state); err != nil {
+		return nil, err
+	}
+
 	return newValue(h.state, result), nil
 }
 
 // Set sets a value on the hash
 func (h *Hash) Set(key, val Value) error {
-	keyVal := key.MrbValue(&Mrb{h.state}).value
-	valVal := val.MrbValue(&Mrb{h.state}).value
-	C.mrb_hash_set(h.state, h.value, keyVal, valVal)
-	return nil
+	defer enterState(h.state)()
+
+	keyVal := key.MrbValue(lookupMrb(h.state)).value
+	valVal := val.MrbValue(lookupMrb(h.state)).value
+	C._go_mrb_hash_set(h.state, h.value, keyVal, valVal)
+	return checkException(h.state)
 }
 
 // Keys returns the array of keys that the Hash has. This is returned
 // as an *MrbValue since this is a Ruby array. You can iterate over it as
 // you see fit.
 func (h *Hash) Keys() (*MrbValue, error) {
+	defer enterState(h.state)()
+
 	result := C.mrb_hash_keys(h.state, h.value)
 	return newValue(h.state, result), nil
 }
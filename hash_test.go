@@ -87,3 +87,39 @@ func TestHash(t *testing.T) {
 		t.Fatalf("bad: %s", value)
 	}
 }
+
+func TestHash_raise(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`
+		class BadKey
+			def hash; raise "nope"; end
+		end
+		[{}, BadKey.new]
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	array := value.Array()
+	hv, err := array.Get(0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	key, err := array.Get(1)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	h := hv.Hash()
+	if err := h.Set(key, String("foo")); err == nil {
+		t.Fatal("set should error")
+	}
+	if _, err := h.Get(key); err == nil {
+		t.Fatal("get should error")
+	}
+	if _, err := h.Delete(key); err == nil {
+		t.Fatal("delete should error")
+	}
+}
@@ -0,0 +1,388 @@
+package mruby
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include "gomruby.h"
+import "C"
+
+// errReleased is returned when a released method is used.
+var errReleased = errors.New("method was released")
+
+// UnboundMethod is a method of a class that isn't tied to a receiver,
+// like UnboundMethod in Ruby. Get one with Class.InstanceMethod and tie
+// it to a receiver with Bind.
+//
+// The method body is looked up once, when the UnboundMethod is created,
+// and is kept alive until Release is called, even if the method is
+// redefined or removed in the meantime. Like a Handle, every
+// UnboundMethod should be released once it is no longer needed.
+type UnboundMethod struct {
+	mrb   *Mrb
+	name  string
+	mid   C.mrb_sym
+	proc  *C.struct_RProc
+	owner *C.struct_RClass
+
+	// handles keep proc and owner alive. They are nil once the method
+	// is released.
+	handles []*Handle
+
+	// missing is set if the method only exists through
+	// respond_to_missing?, in which case proc is method_missing and is
+	// called with the name in front of the arguments.
+	missing bool
+}
+
+// Method is a method bound to a receiver, like Method in Ruby. Get one
+// with MrbValue.Method. Calling it runs the method body directly rather
+// than looking it up by name every time, so it is the fastest way to
+// call the same method over and over.
+//
+// Method has the same lifetime rules as UnboundMethod, and must be
+// released the same way. The receiver must also be kept alive, like any
+// other MrbValue.
+type Method struct {
+	unbound *UnboundMethod
+	recv    *MrbValue
+}
+
+// Method looks up the method with the given name on this value. It
+// returns a NameError as an *Exception if the value doesn't respond to
+// the method. Methods defined in Go are found just like those defined
+// in Ruby, and so are methods that respond_to_missing? says are handled
+// by method_missing.
+func (v *MrbValue) Method(name string) (*Method, error) {
+	defer enterState(v.state)()
+
+	mrb := lookupMrb(v.state)
+	site := pinSite(1)
+	um, err := lookupMethod(mrb, C.mrb_class(v.state, v.value), name, site)
+	if err != nil {
+		missing, merr := v.missingMethod(name, site)
+		if merr != nil {
+			return nil, merr
+		}
+		if missing == nil {
+			return nil, err
+		}
+
+		um = missing
+	}
+
+	return &Method{unbound: um, recv: v}, nil
+}
+
+// InstanceMethod looks up the instance method with the given name. It
+// returns a NameError as an *Exception if instances of the class don't
+// have the method.
+func (c *Class) InstanceMethod(name string) (*UnboundMethod, error) {
+	defer enterState(c.mrb.state)()
+
+	return lookupMethod(c.mrb, c.class, name, pinSite(1))
+}
+
+// Name returns the name of the method.
+func (m *Method) Name() string {
+	return m.unbound.Name()
+}
+
+// Receiver returns the value that the method is bound to.
+func (m *Method) Receiver() *MrbValue {
+	return m.recv
+}
+
+// Owner returns the class or module that defines the method.
+func (m *Method) Owner() *Class {
+	return m.unbound.Owner()
+}
+
+// Arity returns the number of arguments the method takes, like
+// Method#arity in Ruby. See UnboundMethod.Arity.
+func (m *Method) Arity() (int, error) {
+	return m.unbound.Arity()
+}
+
+// SourceLocation returns where the method is defined. See
+// UnboundMethod.SourceLocation.
+func (m *Method) SourceLocation() (string, int, bool) {
+	return m.unbound.SourceLocation()
+}
+
+// Unbind returns the method without its receiver.
+func (m *Method) Unbind() *UnboundMethod {
+	return m.unbound
+}
+
+// Release lets the method body be garbage collected again. The Method
+// shares its UnboundMethod with Unbind and with every other Method bound
+// from it, so this releases all of them. Releasing more than once is
+// fine.
+func (m *Method) Release() {
+	m.unbound.Release()
+}
+
+// Call calls the method with the given arguments. It returns an error if
+// the method was released.
+//
+// mruby can't pass more than MRB_FUNCALL_ARGC_MAX arguments to a method
+// from C, so with more arguments than that, the method is sent by name
+// like MrbValue.Call does. If the receiver doesn't find this method
+// under its name, because it was redefined or is overridden, that is an
+// ArgumentError.
+func (m *Method) Call(args ...Value) (*MrbValue, error) {
+	um := m.unbound
+	defer enterState(um.mrb.state)()
+
+	if um.handles == nil {
+		return nil, errReleased
+	}
+
+	var argv []C.mrb_value
+	var argvPtr *C.mrb_value
+	if len(args) > 0 || um.missing {
+		argv = make([]C.mrb_value, 0, len(args)+1)
+		if um.missing {
+			argv = append(argv, C.mrb_symbol_value(C.mrb_sym(um.mrb.Intern(um.name))))
+		}
+		for _, arg := range args {
+			argv = append(argv, arg.MrbValue(um.mrb).value)
+		}
+
+		argvPtr = &argv[0]
+	}
+
+	if len(argv) > maxFuncallArgs {
+		return m.sendSplat(argv)
+	}
+
+	result := C._go_mrb_method_call(
+		um.mrb.state,
+		m.recv.value,
+		um.mid,
+		um.proc,
+		um.owner,
+		C.mrb_int(len(argv)),
+		argvPtr)
+	if exc := checkException(um.mrb.state); exc != nil {
+		return nil, exc
+	}
+
+	return newValue(um.mrb.state, result), nil
+}
+
+// sendSplat calls the method with more arguments than mruby can pass to
+// a method body from C, by sending it by name with the arguments
+// splatted, like MrbValue.Call does. That only runs the same body if the
+// receiver still finds it under its name, so any other case is an
+// ArgumentError.
+func (m *Method) sendSplat(argv []C.mrb_value) (*MrbValue, error) {
+	um := m.unbound
+	state := um.mrb.state
+
+	mid := um.mid
+	if um.missing {
+		// Sending the name itself reaches method_missing with the name
+		// in front of the arguments.
+		mid = C.mrb_sym(um.mrb.Intern(um.name))
+		argv = argv[1:]
+	} else {
+		var owner *C.struct_RClass
+		class := C.mrb_class(state, m.recv.value)
+		if C._go_mrb_method_search(state, class, mid, &owner) != um.proc {
+			exc := um.mrb.newException("ArgumentError",
+				"too many arguments (%d) to call '%s' other than by its name",
+				len(argv), um.name)
+			return nil, exceptionError(um.mrb, exc)
+		}
+	}
+
+	return um.mrb.sendSplat(m.recv.value, mid, argv, nil)
+}
+
+// Name returns the name of the method.
+func (m *UnboundMethod) Name() string {
+	return m.name
+}
+
+// Release lets the method body be garbage collected again, unless
+// something else still refers to it. The method can't be called after
+// it is released. Releasing more than once is fine.
+func (m *UnboundMethod) Release() {
+	for _, h := range m.handles {
+		h.Release()
+	}
+
+	m.handles = nil
+}
+
+// Owner returns the class or module that defines the method, or nil if
+// the method was released.
+func (m *UnboundMethod) Owner() *Class {
+	if m.handles == nil {
+		return nil
+	}
+
+	return newClass(m.mrb, m.owner)
+}
+
+// Arity returns the number of arguments the method takes, like
+// UnboundMethod#arity in Ruby: the number of required arguments, or -n-1
+// if it also takes optional arguments. The arity of a Go function comes
+// from the ArgSpec it was defined with. Methods written in C other than
+// Go functions always return -1, as do methods handled by
+// method_missing.
+func (m *UnboundMethod) Arity() (int, error) {
+	if m.handles == nil {
+		return 0, errReleased
+	}
+	if m.missing {
+		return -1, nil
+	}
+
+	if f, ok := m.goFunc(); ok {
+		return f.arity(), nil
+	}
+
+	result, err := newValue(m.mrb.state, C.mrb_obj_value(unsafe.Pointer(m.proc))).Call("arity")
+	if err != nil {
+		return 0, err
+	}
+
+	return int(result.Int64()), nil
+}
+
+// SourceLocation returns the file and line where the method is defined.
+// For Go functions, this is where the Go function is. For Ruby methods,
+// this is only known if the code was compiled with a filename set on
+// its CompileContext. ok is false if the location isn't known, or if the
+// method was released.
+func (m *UnboundMethod) SourceLocation() (file string, line int, ok bool) {
+	if m.handles == nil {
+		return "", 0, false
+	}
+
+	if f, ok := m.goFunc(); ok {
+		fn := runtime.FuncForPC(reflect.ValueOf(f.f).Pointer())
+		if fn == nil {
+			return "", 0, false
+		}
+
+		file, line := fn.FileLine(fn.Entry())
+		return file, line, true
+	}
+
+	var cline C.int32_t
+	cfile := C._go_mrb_proc_source(m.proc, &cline)
+	if cfile == nil {
+		return "", 0, false
+	}
+
+	return C.GoString(cfile), int(cline), true
+}
+
+// Bind ties the method to a receiver. The receiver must be an instance
+// of the class that owns the method, unless the owner is a module.
+// Otherwise a TypeError is returned as an *Exception.
+func (m *UnboundMethod) Bind(recv Value) (*Method, error) {
+	defer enterState(m.mrb.state)()
+
+	if m.handles == nil {
+		return nil, errReleased
+	}
+
+	v := recv.MrbValue(m.mrb)
+
+	owner := m.Owner()
+	if !owner.IsModule() {
+		ok, err := v.IsA(owner)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			exc := m.mrb.newException(
+				"TypeError", "bind argument must be an instance of %s", owner.Name())
+			return nil, exceptionError(m.mrb, exc)
+		}
+	}
+
+	return &Method{unbound: m, recv: v}, nil
+}
+
+// missingMethod returns the method_missing of the value as the method
+// with the given name, if respond_to_missing? says it handles the name.
+// It returns nil if it doesn't. site is as for lookupMethod.
+func (v *MrbValue) missingMethod(name string, site string) (*UnboundMethod, error) {
+	mrb := lookupMrb(v.state)
+	class := C.mrb_class(v.state, v.value)
+
+	rtm, err := lookupMethod(mrb, class, "respond_to_missing?", site)
+	if err != nil {
+		return nil, nil
+	}
+	defer rtm.Release()
+
+	result, err := (&Method{unbound: rtm, recv: v}).Call(Symbol(name), Bool(true))
+	if err != nil {
+		return nil, err
+	}
+	if !result.Truthy() {
+		return nil, nil
+	}
+
+	um, err := lookupMethod(mrb, class, "method_missing", site)
+	if err != nil {
+		return nil, nil
+	}
+
+	um.name = name
+	um.missing = true
+	return um, nil
+}
+
+// goFunc returns the Go function that the method calls, if it is a
+// method defined in Go.
+func (m *UnboundMethod) goFunc() (methodFunc, bool) {
+	id := int(C._go_mrb_proc_func_id(m.proc))
+	if id < 0 || id >= len(m.mrb.funcs) {
+		return methodFunc{}, false
+	}
+
+	return m.mrb.funcs[id], true
+}
+
+// lookupMethod finds the method with the given name for instances of c,
+// and keeps its body and owner alive until it is released. site is where
+// the method was asked for, for the leak report.
+func lookupMethod(mrb *Mrb, c *C.struct_RClass, name string, site string) (*UnboundMethod, error) {
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+
+	mid := C.mrb_intern_cstr(mrb.state, cs)
+
+	var owner *C.struct_RClass
+	proc := C._go_mrb_method_search(mrb.state, c, mid, &owner)
+	if proc == nil {
+		exc := mrb.newException(
+			"NameError", "undefined method '%s' for class '%s'",
+			name, newClass(mrb, c).Name())
+		return nil, exceptionError(mrb, exc)
+	}
+
+	return &UnboundMethod{
+		mrb:   mrb,
+		name:  name,
+		mid:   mid,
+		proc:  proc,
+		owner: owner,
+		handles: []*Handle{
+			mrb.pin(C.mrb_obj_value(unsafe.Pointer(proc)), site),
+			mrb.pin(C.mrb_obj_value(unsafe.Pointer(owner)), site),
+		},
+	}, nil
+}
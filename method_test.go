@@ -0,0 +1,418 @@
+package mruby
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMrbValueMethod(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	parser := NewParser(mrb)
+	defer parser.Close()
+	context := NewCompileContext(mrb)
+	context.SetFilename("hello.rb")
+	defer context.Close()
+
+	if _, err := parser.Parse(`
+		module Greeting
+			def greet(name, punct = "!")
+				"hello #{name}#{punct}"
+			end
+		end
+
+		class Base
+			include Greeting
+
+			def which
+				[__method__, "base"]
+			end
+		end
+
+		class Hello < Base
+			def which
+				super + ["hello"]
+			end
+		end
+
+		Hello.new
+	`, context); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	instance, err := mrb.Run(parser.GenerateCode(), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	method, err := instance.Method("greet")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if method.Name() != "greet" {
+		t.Fatalf("bad: %s", method.Name())
+	}
+	if name := method.Owner().Name(); name != "Greeting" {
+		t.Fatalf("bad: %s", name)
+	}
+	if arity, err := method.Arity(); err != nil || arity != -2 {
+		t.Fatalf("bad: %d %s", arity, err)
+	}
+	if file, line, ok := method.SourceLocation(); !ok || file != "hello.rb" || line != 3 {
+		t.Fatalf("bad: %s %d %v", file, line, ok)
+	}
+
+	for i := 0; i < 3; i++ {
+		result, err := method.Call(String("world"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if result.String() != "hello world!" {
+			t.Fatalf("bad: %s", result)
+		}
+	}
+
+	// super and __method__ see the method's own name
+	method, err = instance.Method("which")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result, err := method.Call()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.String() != `[:which, "base", "hello"]` {
+		t.Fatalf("bad: %s", result)
+	}
+
+	// Arguments are still checked
+	if _, err := method.Call(Int(1)); err == nil {
+		t.Fatal("should error")
+	}
+
+	_, err = instance.Method("nope")
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if exc, ok := err.(*Exception); !ok || exc.Class().Name() != "NameError" {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestMrbValueMethod_goFunc(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var calls int
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		calls++
+
+		args := m.GetArgs()
+		if args[0].Fixnum() < 0 {
+			return nil, m.newException("ArgumentError", "negative")
+		}
+
+		return Int(args[0].Fixnum() + args[1].Fixnum()), nil
+	}
+
+	class := mrb.DefineClass("Hello", nil)
+	class.DefineMethod("add", cb, ArgsReq(2))
+	class.DefineMethod("opt", cb, ArgsReq(1)|ArgsOpt(1))
+	class.DefineMethodKeywords("key", cb, ArgsReq(1), Keywords{Required: []string{"a"}})
+
+	instance, err := class.New()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	arities := map[string]int{"add": 2, "opt": -2, "key": 2}
+	for name, expected := range arities {
+		method, err := instance.Method(name)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if arity, err := method.Arity(); err != nil || arity != expected {
+			t.Fatalf("bad %s: %d %s", name, arity, err)
+		}
+	}
+
+	method, err := instance.Method("add")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if name := method.Owner().Name(); name != "Hello" {
+		t.Fatalf("bad: %s", name)
+	}
+	file, _, ok := method.SourceLocation()
+	if !ok || filepath.Base(file) != "method_test.go" {
+		t.Fatalf("bad: %s %v", file, ok)
+	}
+
+	// An exception from Go leaves the VM in a state where the method can
+	// be called again.
+	for i := 0; i < 3; i++ {
+		if _, err := method.Call(Int(-1), Int(2)); err == nil {
+			t.Fatal("should error")
+		}
+
+		result, err := method.Call(Int(1), Int(2))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if result.Fixnum() != 3 {
+			t.Fatalf("bad: %s", result)
+		}
+	}
+	if calls != 6 {
+		t.Fatalf("bad: %d", calls)
+	}
+}
+
+func TestClassInstanceMethod(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	_, err := mrb.LoadString(`
+		class Hello
+			def initialize(name); @name = name; end
+			def name; @name; end
+		end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	class, err := mrb.LookupClass("Hello")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	unbound, err := class.InstanceMethod("name")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if arity, err := unbound.Arity(); err != nil || arity != 0 {
+		t.Fatalf("bad: %d %s", arity, err)
+	}
+	if _, _, ok := unbound.SourceLocation(); ok {
+		t.Fatal("should not know the location")
+	}
+
+	for _, name := range []string{"foo", "bar"} {
+		instance, err := class.New(String(name))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		method, err := unbound.Bind(instance)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if method.Unbind() != unbound {
+			t.Fatal("bad unbind")
+		}
+
+		result, err := method.Call()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if result.String() != name {
+			t.Fatalf("bad: %s", result)
+		}
+	}
+
+	_, err = unbound.Bind(String("nope"))
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if exc, ok := err.(*Exception); !ok || exc.Class().Name() != "TypeError" {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	if _, err := class.InstanceMethod("nope"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestMethodRelease(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	_, err := mrb.LoadString(`
+		class Hello
+			def foo; "old"; end
+		end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	instance, err := mrb.LoadString(`Hello.new`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	method, err := instance.Method("foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The old body is kept alive after the method is redefined
+	if _, err := mrb.LoadString(`class Hello; def foo; "new"; end; end`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	mrb.FullGC()
+
+	result, err := method.Call()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.String() != "old" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	method.Release()
+	method.Release()
+	if n := len(mrb.pinned) - len(mrb.freeHandles); n != 0 {
+		t.Fatalf("bad: %d handles still pinned", n)
+	}
+
+	if _, err := method.Call(); err != errReleased {
+		t.Fatalf("bad: %v", err)
+	}
+	if _, err := method.Arity(); err != errReleased {
+		t.Fatalf("bad: %v", err)
+	}
+	if _, err := method.Unbind().Bind(instance); err != errReleased {
+		t.Fatalf("bad: %v", err)
+	}
+	if method.Owner() != nil {
+		t.Fatal("owner should be nil")
+	}
+}
+
+func BenchmarkMethodCall(b *testing.B) {
+	mrb, self, args := benchmarkCallVM(b)
+	defer mrb.Close()
+
+	method, err := self.Method("evaluate")
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+
+	b.ReportAllocs()
+	ai := mrb.ArenaSave()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := method.Call(args...); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+
+		mrb.ArenaRestore(ai)
+	}
+}
+
+func TestMethodCall_manyArgs(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var count int
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		count = len(m.GetArgs())
+		return Int(count), nil
+	}
+
+	_, err := mrb.LoadString(`
+		class Base
+			def count(*args); args.size; end
+			def which(*args); :base; end
+		end
+
+		class Sub < Base
+			def which(*args); :sub; end
+			def respond_to_missing?(name, priv); name == :ghost; end
+			def method_missing(name, *args); [name, args.size]; end
+		end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	base, err := mrb.LookupClass("Base")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	base.DefineMethod("count_args", cb, ArgsAny())
+
+	sub, err := mrb.LoadString(`Sub.new`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	args := make([]Value, 100)
+	for i := range args {
+		args[i] = Int(i)
+	}
+
+	// Ruby methods, below and above the limit
+	method, err := sub.Method("count")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer method.Release()
+	for _, n := range []int{10, 100} {
+		result, err := method.Call(args[:n]...)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if result.Fixnum() != n {
+			t.Fatalf("bad: %s", result)
+		}
+	}
+
+	// Go functions
+	method, err = sub.Method("count_args")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer method.Release()
+	if _, err := method.Call(args...); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if count != 100 {
+		t.Fatalf("bad: %d", count)
+	}
+
+	// Methods handled by method_missing
+	method, err = sub.Method("ghost")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer method.Release()
+	result, err := method.Call(args...)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.String() != "[:ghost, 100]" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	// A method that the receiver doesn't find by its name can't be sent
+	unbound, err := base.InstanceMethod("which")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer unbound.Release()
+	method, err = unbound.Bind(sub)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result, err := method.Call(args[:3]...); err != nil || result.String() != "base" {
+		t.Fatalf("bad: %s %s", result, err)
+	}
+	_, err = method.Call(args...)
+	if exc, ok := err.(*Exception); !ok || exc.Class().Name() != "ArgumentError" {
+		t.Fatalf("bad: %#v", err)
+	}
+}
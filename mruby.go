@@ -1,6 +1,12 @@
 package mruby
 
-import "unsafe"
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unsafe"
+)
 
 // #cgo CFLAGS: -Imruby-build/mruby/include
 // #cgo LDFLAGS: ${SRCDIR}/libmruby.a -lm
@@ -10,11 +16,94 @@ import "C"
 
 // Mrb represents a single instance of mruby.
 type Mrb struct {
-	state *C.mrb_state
+	state   *C.mrb_state
+	options Options
+
+	// uncaught counts the exceptions that escaped from Ruby code run
+	// with LoadString, Run or RunWithContext outside of a Go function.
+	// Pool uses this to decide whether a VM can be reused. Errors from
+	// calls like Call are left to the caller, which may handle them.
+	uncaught int
+
+	// funcDepth is the number of Go functions called from Ruby that are
+	// currently running.
+	funcDepth int
+
+	// funcs are the Go functions exposed to Ruby in this VM. A function's
+	// index is its id, which is stored on the Ruby proc that calls it.
+	// The slot of a function whose proc was garbage collected is listed
+	// in freeFuncs to be reused. This doesn't need a lock since a VM is
+	// only ever used by one goroutine at a time.
+	funcs     []methodFunc
+	freeFuncs []int
+
+	// userData holds the values set with SetUserData.
+	userData map[interface{}]interface{}
+
+	// structClasses are the classes defined with DefineStructClass, by
+	// the Go type they wrap. structs are the pointers to Go structs that
+	// are wrapped by Ruby objects, by the id stored in the object.
+	structClasses map[reflect.Type]*Class
+	structs       map[int]reflect.Value
+	nextStruct    int
+
+	// splatSender is the lambda used by sendSplat. It is created on
+	// first use and kept alive by a hidden instance variable of TopSelf.
+	splatSender *MrbValue
+
+	// handles is the array that keeps pinned values alive. pinned are
+	// the handles by their index in the array, with nil for the free
+	// slots listed in freeHandles.
+	handles     *MrbValue
+	pinned      []*Handle
+	freeHandles []int
+}
+
+// stateRegistry maps each *C.mrb_state to the *Mrb that owns it, so that
+// callbacks and values always get back the same *Mrb (along with any
+// state attached to it) instead of a fresh wrapper. This is cleaned up
+// by Mrb.Close.
+var stateRegistry sync.Map
+
+// lookupMrb returns the *Mrb for a state. If the state is unknown, which
+// only happens if the Mrb was already closed, a bare *Mrb is returned.
+func lookupMrb(s *C.mrb_state) *Mrb {
+	if m, ok := stateRegistry.Load(s); ok {
+		return m.(*Mrb)
+	}
+
+	return &Mrb{state: s}
+}
+
+// freeingMrb returns the *Mrb for a state from the free function of a
+// data type. The state is already unregistered if the object is freed
+// while the Mrb is being closed, in which case there is nothing to clean
+// up and false is returned.
+func freeingMrb(s *C.mrb_state) (*Mrb, bool) {
+	value, ok := stateRegistry.Load(s)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(*Mrb), true
+}
+
+// setHiddenRoot keeps a value from being garbage collected for the
+// lifetime of the VM by storing it in an instance variable of TopSelf.
+// The name has no leading "@", so the variable can't be seen or changed
+// from Ruby.
+func (m *Mrb) setHiddenRoot(name string, value C.mrb_value) error {
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+
+	C._go_mrb_iv_set(m.state, C.mrb_top_self(m.state), C.mrb_intern_cstr(m.state, cs), value)
+	return checkException(m.state)
 }
 
 // GetGlobalVariable returns the value of the global variable by the given name.
 func (m *Mrb) GetGlobalVariable(name string) *MrbValue {
+	defer enterState(m.state)()
+
 	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
 	return newValue(m.state, C._go_mrb_gv_get(m.state, C.mrb_intern_cstr(m.state, cs)))
@@ -22,6 +111,8 @@ func (m *Mrb) GetGlobalVariable(name string) *MrbValue {
 
 // SetGlobalVariable sets the value of the global variable by the given name.
 func (m *Mrb) SetGlobalVariable(name string, value Value) {
+	defer enterState(m.state)()
+
 	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
 
@@ -29,6 +120,32 @@ func (m *Mrb) SetGlobalVariable(name string, value Value) {
 	C._go_mrb_gv_set(m.state, C.mrb_intern_cstr(m.state, cs), v.value)
 }
 
+// GlobalVariables returns the names of the global variables, including
+// their leading "$". Globals that are only used internally have names
+// without a "$", which Ruby code can't reach, and are left out.
+func (m *Mrb) GlobalVariables() []string {
+	defer enterState(m.state)()
+
+	names, err := m.TopSelf().Call("global_variables")
+	if err != nil {
+		return nil
+	}
+
+	all, err := stringSlice(names)
+	if err != nil {
+		return nil
+	}
+
+	result := make([]string, 0, len(all))
+	for _, name := range all {
+		if strings.HasPrefix(name, "$") {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
 // ArenaIndex represents the index into the arena portion of the GC.
 //
 // See ArenaSave for more information.
@@ -40,11 +157,34 @@ type ArenaIndex int
 // When you're finished with the VM, clean up all resources it is using
 // by calling the Close method.
 func NewMrb() *Mrb {
+	return NewMrbWithOptions(Options{})
+}
+
+// NewMrbWithOptions is like NewMrb, but configures the VM with the given
+// options.
+func NewMrbWithOptions(opts Options) *Mrb {
+	if checkOwnershipDefault {
+		opts.CheckOwnership = true
+	}
+
 	state := C.mrb_open()
+	if opts.CheckOwnership {
+		registerOwnership(state)
+	}
 
-	return &Mrb{
-		state: state,
+	m := &Mrb{
+		state:   state,
+		options: opts,
 	}
+	stateRegistry.Store(state, m)
+
+	// The array of pinned values is set up here rather than on the first
+	// Pin, since TopSelf can't be frozen yet and so this can't fail.
+	handles := C.mrb_ary_new(state)
+	m.setHiddenRoot(handlesName, handles)
+	m.handles = newValue(state, handles)
+
+	return m
 }
 
 // ArenaRestore restores the arena index so the objects between the save and this point
@@ -52,6 +192,8 @@ func NewMrb() *Mrb {
 //
 // See ArenaSave for more documentation.
 func (m *Mrb) ArenaRestore(idx ArenaIndex) {
+	defer enterState(m.state)()
+
 	C.mrb_gc_arena_restore(m.state, C.int(idx))
 }
 
@@ -74,109 +216,247 @@ func (m *Mrb) ArenaRestore(idx ArenaIndex) {
 // period of time, you might not have to worry about saving/restoring the
 // arena.
 func (m *Mrb) ArenaSave() ArenaIndex {
+	defer enterState(m.state)()
+
 	return ArenaIndex(C.mrb_gc_arena_save(m.state))
 }
 
 // EnableGC enables the garbage collector for this mruby instance. It returns
 // true if garbage collection was previously disabled.
 func (m *Mrb) EnableGC() {
+	defer enterState(m.state)()
+
 	C._go_enable_gc(m.state)
 }
 
 // DisableGC disables the garbage collector for this mruby instance. It returns
 // true if it was previously disabled.
 func (m *Mrb) DisableGC() {
+	defer enterState(m.state)()
+
 	C._go_disable_gc(m.state)
 }
 
 // LiveObjectCount returns the number of objects that have not been collected (aka, alive).
 func (m *Mrb) LiveObjectCount() int {
+	defer enterState(m.state)()
+
 	return int(C._go_gc_live(m.state))
 }
 
 // Class returns the class with the kgiven name and superclass. Note that
-// if you call this with a class that doesn't exist, mruby will abort the
-// application (like a panic, but not a Go panic).
+// if you call this with a class that doesn't exist, this panics with the
+// NameError as an *Exception. Use LookupClass to get an error instead.
 //
 // super can be nil, in which case the Object class will be used.
 func (m *Mrb) Class(name string, super *Class) *Class {
-	cs := C.CString(name)
-	defer C.free(unsafe.Pointer(cs))
+	defer enterState(m.state)()
 
-	var class *C.struct_RClass
 	if super == nil {
-		class = C.mrb_class_get(m.state, cs)
-	} else {
-		class = C.mrb_class_get_under(m.state, super.class, cs)
+		super = m.ObjectClass()
 	}
 
-	return newClass(m, class)
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+
+	return mustClass(m.classResult(C._go_mrb_class_get_under(m.state, super.class, cs)))
 }
 
 // Module returns the named module as a *Class. If the module is invalid,
-// NameError is triggered within your program and SIGABRT is sent to the
-// application.
+// this panics with the NameError as an *Exception. Use LookupModule to
+// get an error instead.
 func (m *Mrb) Module(name string) *Class {
+	defer enterState(m.state)()
+
 	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
 
-	class := C.mrb_module_get(m.state, cs)
+	return mustClass(m.classResult(C._go_mrb_module_get(m.state, cs)))
+}
+
+// LookupClass returns the class at the given path, such as "Foo" or
+// "Foo::Bar::Baz". Paths are resolved from the top-level, like in Ruby
+// code.
+//
+// If a constant along the path doesn't exist, the returned error is the
+// NameError raised by mruby as an *Exception. If the constant isn't a
+// class, it is a TypeError.
+func (m *Mrb) LookupClass(path string) (*Class, error) {
+	defer enterState(m.state)()
+
+	return m.lookupClass(path, C.MRB_TT_CLASS)
+}
+
+// LookupModule is like LookupClass, but for modules.
+func (m *Mrb) LookupModule(path string) (*Class, error) {
+	defer enterState(m.state)()
 
-	return newClass(m, class)
+	return m.lookupClass(path, C.MRB_TT_MODULE)
+}
+
+func (m *Mrb) lookupClass(path string, tt C.enum_mrb_vtype) (*Class, error) {
+	defer enterState(m.state)()
+
+	value := C.mrb_obj_value(unsafe.Pointer(m.state.object_class))
+	for _, name := range strings.Split(strings.TrimPrefix(path, "::"), "::") {
+		cs := C.CString(name)
+		sym := C.mrb_intern_cstr(m.state, cs)
+		C.free(unsafe.Pointer(cs))
+
+		value = C._go_mrb_const_get(m.state, value, sym)
+		if err := checkException(m.state); err != nil {
+			return nil, err
+		}
+	}
+
+	value = C._go_mrb_check_type(m.state, value, tt)
+	if err := checkException(m.state); err != nil {
+		return nil, err
+	}
+
+	return newClass(m, C._go_mrb_class_ptr(value)), nil
 }
 
 // Close a Mrb, this must be called to properly free resources, and
 // should only be called once.
 func (m *Mrb) Close() {
-	// Delete all the methods from the state
-	stateMethodTable.Mutex.Lock()
-	delete(stateMethodTable.Map, m.state)
-	stateMethodTable.Mutex.Unlock()
+	exit := enterState(m.state)
+	unregisterOwnership(m.state)
+	exit()
+
+	// Forget about the state, along with the methods and user data
+	stateRegistry.Delete(m.state)
+	m.funcs = nil
+	m.freeFuncs = nil
+	m.userData = nil
+	m.structClasses = nil
+	m.structs = nil
+
+	// Report the handles that were never released. Releasing them later
+	// is a no-op.
+	m.reportHandleLeaks()
+	m.pinned = nil
+	m.freeHandles = nil
 
 	// Close the state
 	C.mrb_close(m.state)
 }
 
-// ConstDefined checks if the given constant is defined in the scope.
+// SetUserData attaches a value to the VM under the given key, replacing
+// any previous value for that key. Setting a nil value removes the key.
+//
+// This lets a Func reach host objects through the *Mrb it is called with
+// instead of through globals. Keys follow the same rules as keys in a
+// Go map; to avoid collisions between packages, use an unexported type
+// for them like with context.Context.
+func (m *Mrb) SetUserData(key, value interface{}) {
+	if value == nil {
+		delete(m.userData, key)
+		return
+	}
+
+	if m.userData == nil {
+		m.userData = make(map[interface{}]interface{})
+	}
+
+	m.userData[key] = value
+}
+
+// UserData returns the value attached to the VM under the given key with
+// SetUserData, or nil if there is none.
+func (m *Mrb) UserData(key interface{}) interface{} {
+	return m.userData[key]
+}
+
+// ConstDefined checks if the given constant is defined in the scope,
+// which must be a class or module, such as a *Class. If scope is nil,
+// the constant is looked up in Object. For any other scope, this returns
+// false without asking mruby, which only handles classes and modules.
 //
 // This should be used, for example, before a call to Class, because a
-// failure in Class will crash your program (by design). You can retrieve
-// the Value of a Class by calling Value().
+// failure in Class will panic.
 func (m *Mrb) ConstDefined(name string, scope Value) bool {
+	defer enterState(m.state)()
+
+	if scope == nil {
+		scope = m.ObjectClass()
+	}
+
+	scopeV := scope.MrbValue(m)
+	if t := scopeV.Type(); t != TypeClass && t != TypeModule {
+		return false
+	}
+
 	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
 
-	scopeV := scope.MrbValue(m).value
-	b := C.mrb_const_defined(
-		m.state, scopeV, C.mrb_intern_cstr(m.state, cs))
-	return C.ushort(b) != 0
+	b := C._go_mrb_const_defined(
+		m.state, scopeV.value, C.mrb_intern_cstr(m.state, cs))
+	if checkException(m.state) != nil {
+		return false
+	}
+
+	return C._go_mrb_test(b) != 0
 }
 
 // FullGC executes a complete GC cycle on the VM.
 func (m *Mrb) FullGC() {
+	defer enterState(m.state)()
+
 	C.mrb_full_gc(m.state)
 }
 
 // GetArgs returns all the arguments that were given to the currnetly
-// called function (currently on the stack).
+// called function (currently on the stack). If a block was given, it is
+// the last element.
 func (m *Mrb) GetArgs() []*MrbValue {
-	getArgLock.Lock()
-	defer getArgLock.Unlock()
+	defer enterState(m.state)()
+
+	values, block := m.getArgs()
+	if block != nil {
+		values = append(values, block)
+	}
+
+	return values
+}
+
+// Block returns the block given to the currently called function, and
+// whether one was given. Unlike GetArgs, this tells a block apart from a
+// Proc that was passed as the last argument. Use Proc on the result to
+// call it.
+func (m *Mrb) Block() (*MrbValue, bool) {
+	defer enterState(m.state)()
+
+	_, block := m.getArgs()
+	return block, block != nil
+}
+
+// BlockGiven tells you if a block was given to the currently called
+// function, like block_given? in Ruby.
+func (m *Mrb) BlockGiven() bool {
+	defer enterState(m.state)()
 
-	// Clear reset the accumulator to zero length
-	getArgAccumulator = make([]C.mrb_value, 0, C._go_get_max_funcall_args())
+	_, ok := m.Block()
+	return ok
+}
 
-	// Get all the arguments and put it into our accumulator
-	count := C._go_mrb_get_args_all(m.state)
+// getArgs returns the arguments given to the currently called function
+// and the block separately. The block is nil if none was given.
+func (m *Mrb) getArgs() ([]*MrbValue, *MrbValue) {
+	var argv *C.mrb_value
+	var block C.mrb_value
+	argc := int(C._go_mrb_get_args_all(m.state, &argv, &block))
 
-	// Convert those all to values
-	values := make([]*MrbValue, count)
+	values := make([]*MrbValue, 0, argc+1)
+	for _, arg := range cValues(argv, argc) {
+		values = append(values, newValue(m.state, arg))
+	}
 
-	for i := 0; i < int(count); i++ {
-		values[i] = newValue(m.state, getArgAccumulator[i])
+	if C._go_mrb_nil_p(block) != 0 {
+		return values, nil
 	}
 
-	return values
+	return values, newValue(m.state, block)
 }
 
 // IncrementalGC runs an incremental GC step. It is much less expensive
@@ -186,17 +466,22 @@ func (m *Mrb) GetArgs() []*MrbValue {
 // This function is best called periodically when executing Ruby in
 // the VM many times (thousands of times).
 func (m *Mrb) IncrementalGC() {
+	defer enterState(m.state)()
+
 	C.mrb_incremental_gc(m.state)
 }
 
 // LoadString loads the given code, executes it, and returns its final
 // value that it might return.
 func (m *Mrb) LoadString(code string) (*MrbValue, error) {
+	defer enterState(m.state)()
+
 	cs := C.CString(code)
 	defer C.free(unsafe.Pointer(cs))
 
 	value := C._go_mrb_load_string(m.state, cs)
 	if exc := checkException(m.state); exc != nil {
+		m.noteUncaught()
 		return nil, exc
 	}
 
@@ -209,6 +494,8 @@ func (m *Mrb) LoadString(code string) (*MrbValue, error) {
 //
 // If self is nil, it is set to the top-level self.
 func (m *Mrb) Run(v Value, self Value) (*MrbValue, error) {
+	defer enterState(m.state)()
+
 	if self == nil {
 		self = m.TopSelf()
 	}
@@ -217,9 +504,10 @@ func (m *Mrb) Run(v Value, self Value) (*MrbValue, error) {
 	mrbSelf := self.MrbValue(m)
 
 	proc := C._go_mrb_proc_ptr(mrbV.value)
-	value := C.mrb_run(m.state, proc, mrbSelf.value)
+	value := C._go_mrb_run(m.state, proc, mrbSelf.value)
 
 	if exc := checkException(m.state); exc != nil {
+		m.noteUncaught()
 		return nil, exc
 	}
 
@@ -233,6 +521,8 @@ func (m *Mrb) Run(v Value, self Value) (*MrbValue, error) {
 //
 // Otherwise, it is very similar in function to Run()
 func (m *Mrb) RunWithContext(v Value, self Value, stackKeep int) (int, *MrbValue, error) {
+	defer enterState(m.state)()
+
 	if self == nil {
 		self = m.TopSelf()
 	}
@@ -246,16 +536,27 @@ func (m *Mrb) RunWithContext(v Value, self Value, stackKeep int) (int, *MrbValue
 	value := C._go_mrb_context_run(m.state, proc, mrbSelf.value, &i)
 
 	if exc := checkException(m.state); exc != nil {
+		m.noteUncaught()
 		return stackKeep, nil, exc
 	}
 
 	return int(i), newValue(m.state, value), nil
 }
 
+// noteUncaught records an exception that escaped from Ruby to Go, unless
+// it happened inside a Go function, which gets to handle it.
+func (m *Mrb) noteUncaught() {
+	if m.funcDepth == 0 {
+		m.uncaught++
+	}
+}
+
 // Yield yields to a block with the given arguments.
 //
 // This should be called within the context of a Func.
 func (m *Mrb) Yield(block Value, args ...Value) (*MrbValue, error) {
+	defer enterState(m.state)()
+
 	mrbBlock := block.MrbValue(m)
 
 	var argv []C.mrb_value
@@ -271,6 +572,10 @@ func (m *Mrb) Yield(block Value, args ...Value) (*MrbValue, error) {
 		argvPtr = &argv[0]
 	}
 
+	if len(argv) > maxFuncallArgs {
+		return m.sendSplat(mrbBlock.value, C.mrb_sym(m.Intern("call")), argv, nil)
+	}
+
 	result := C._go_mrb_yield_argv(
 		m.state,
 		mrbBlock.value,
@@ -291,23 +596,37 @@ func (m *Mrb) Yield(block Value, args ...Value) (*MrbValue, error) {
 // DefineClass defines a new top-level class.
 //
 // If super is nil, the class will be defined under Object.
+//
+// If the name is already taken by a constant that isn't a class, or by a
+// class with a different superclass, mruby raises a TypeError. Since
+// there is no error to return, this panics with that *Exception. Use
+// TryDefineClass to get an error instead.
 func (m *Mrb) DefineClass(name string, super *Class) *Class {
-	if super == nil {
-		super = m.ObjectClass()
-	}
-
-	cs := C.CString(name)
-	defer C.free(unsafe.Pointer(cs))
+	return mustClass(m.TryDefineClass(name, super))
+}
 
-	return newClass(
-		m, C.mrb_define_class(m.state, cs, super.class))
+// TryDefineClass is like DefineClass, but returns the TypeError as an
+// *Exception instead of panicking if the name is taken.
+func (m *Mrb) TryDefineClass(name string, super *Class) (*Class, error) {
+	return m.TryDefineClassUnder(name, super, nil)
 }
 
 // DefineClassUnder defines a new class under another class.
 //
 // This is, for example, how you would define the World class in
 // `Hello::World` where Hello is the "outer" class.
+//
+// This panics the same way as DefineClass if the name is taken. Use
+// TryDefineClassUnder to get an error instead.
 func (m *Mrb) DefineClassUnder(name string, super *Class, outer *Class) *Class {
+	return mustClass(m.TryDefineClassUnder(name, super, outer))
+}
+
+// TryDefineClassUnder is like DefineClassUnder, but returns an error
+// the same way as TryDefineClass if the name is taken.
+func (m *Mrb) TryDefineClassUnder(name string, super *Class, outer *Class) (*Class, error) {
+	defer enterState(m.state)()
+
 	if super == nil {
 		super = m.ObjectClass()
 	}
@@ -318,19 +637,38 @@ func (m *Mrb) DefineClassUnder(name string, super *Class, outer *Class) *Class {
 	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
 
-	return newClass(m, C.mrb_define_class_under(
+	return m.classResult(C._go_mrb_define_class_under(
 		m.state, outer.class, cs, super.class))
 }
 
 // DefineModule defines a top-level module.
+//
+// This panics the same way as DefineClass if the name is taken by a
+// constant that isn't a module. Use TryDefineModule to get an error
+// instead.
 func (m *Mrb) DefineModule(name string) *Class {
-	cs := C.CString(name)
-	defer C.free(unsafe.Pointer(cs))
-	return newClass(m, C.mrb_define_module(m.state, cs))
+	return mustClass(m.TryDefineModule(name))
+}
+
+// TryDefineModule is like DefineModule, but returns the TypeError as an
+// *Exception instead of panicking if the name is taken.
+func (m *Mrb) TryDefineModule(name string) (*Class, error) {
+	return m.TryDefineModuleUnder(name, nil)
 }
 
 // DefineModuleUnder defines a module under another class/module.
+//
+// This panics the same way as DefineModule if the name is taken. Use
+// TryDefineModuleUnder to get an error instead.
 func (m *Mrb) DefineModuleUnder(name string, outer *Class) *Class {
+	return mustClass(m.TryDefineModuleUnder(name, outer))
+}
+
+// TryDefineModuleUnder is like DefineModuleUnder, but returns an error
+// the same way as TryDefineModule if the name is taken.
+func (m *Mrb) TryDefineModuleUnder(name string, outer *Class) (*Class, error) {
+	defer enterState(m.state)()
+
 	if outer == nil {
 		outer = m.ObjectClass()
 	}
@@ -338,8 +676,28 @@ func (m *Mrb) DefineModuleUnder(name string, outer *Class) *Class {
 	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
 
-	return newClass(m,
-		C.mrb_define_module_under(m.state, outer.class, cs))
+	return m.classResult(C._go_mrb_define_module_under(
+		m.state, outer.class, cs))
+}
+
+// classResult turns the result of a protected call that returns a class
+// into a *Class, or the exception if one was raised.
+func (m *Mrb) classResult(value C.mrb_value) (*Class, error) {
+	if err := checkException(m.state); err != nil {
+		return nil, err
+	}
+
+	return newClass(m, C._go_mrb_class_ptr(value)), nil
+}
+
+// mustClass panics with the error, if any, from one of the functions
+// that return a class.
+func mustClass(c *Class, err error) *Class {
+	if err != nil {
+		panic(err)
+	}
+
+	return c
 }
 
 //-------------------------------------------------------------------
@@ -377,15 +735,95 @@ func (m *Mrb) TrueValue() *MrbValue {
 }
 
 // FixnumValue returns a Value for a fixed number.
+//
+// The range of a fixnum depends on how mruby was built (MRB_INT16,
+// MRB_INT64 or the default of 32 bits). If v doesn't fit, the result is
+// a Float instead, the same way integer arithmetic in mruby overflows
+// into floats. Use Int64Value to get an error instead.
 func (m *Mrb) FixnumValue(v int) *MrbValue {
-	return newValue(m.state, C.mrb_fixnum_value(C.mrb_int(v)))
+	value, err := m.Int64Value(int64(v))
+	if err != nil {
+		return m.FloatValue(float64(v))
+	}
+
+	return value
 }
 
-// StringValue returns a Value for a string.
-func (m *Mrb) StringValue(s string) *MrbValue {
-	cs := C.CString(s)
+// Int64Value returns a Value for a fixed number. An error is returned if
+// v doesn't fit in the integer type mruby was built with.
+func (m *Mrb) Int64Value(v int64) (*MrbValue, error) {
+	defer enterState(m.state)()
+
+	min := int64(C._go_MRB_INT_MIN())
+	max := int64(C._go_MRB_INT_MAX())
+	if v < min || v > max {
+		return nil, fmt.Errorf(
+			"integer %d out of range for mruby fixnum (%d to %d)", v, min, max)
+	}
+
+	return newValue(m.state, C.mrb_fixnum_value(C.mrb_int(v))), nil
+}
+
+// FloatValue returns a Value for a float.
+func (m *Mrb) FloatValue(f float64) *MrbValue {
+	return newValue(m.state, C.mrb_float_value(m.state, C.mrb_float(f)))
+}
+
+// SymbolValue returns a Value for the symbol with the given name. The
+// name should not include the leading colon.
+func (m *Mrb) SymbolValue(name string) *MrbValue {
+	defer enterState(m.state)()
+
+	cs := C.CString(name)
 	defer C.free(unsafe.Pointer(cs))
-	return newValue(m.state, C.mrb_str_new_cstr(m.state, cs))
+	return newValue(m.state, C.mrb_symbol_value(C.mrb_intern_cstr(m.state, cs)))
+}
+
+// Intern returns the symbol for the given name, which can then be used
+// with CallSym. The symbol is only valid in this VM.
+func (m *Mrb) Intern(name string) Sym {
+	defer enterState(m.state)()
+
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+	return Sym(C.mrb_intern_cstr(m.state, cs))
+}
+
+// StringValue returns a Value for a string. The string may contain NUL
+// bytes.
+func (m *Mrb) StringValue(s string) *MrbValue {
+	return m.BytesValue([]byte(s))
+}
+
+// BytesValue returns a Value for a Ruby string holding the given bytes.
+// The bytes are copied, so b can be reused after this returns.
+func (m *Mrb) BytesValue(b []byte) *MrbValue {
+	defer enterState(m.state)()
+
+	var ptr *C.char
+	if len(b) > 0 {
+		ptr = (*C.char)(unsafe.Pointer(&b[0]))
+	}
+
+	return newValue(m.state, C.mrb_str_new(m.state, ptr, C.size_t(len(b))))
+}
+
+// cValues turns a C array of values into a Go slice without copying.
+func cValues(argv *C.mrb_value, argc int) []C.mrb_value {
+	if argc == 0 {
+		return nil
+	}
+
+	return (*[1 << 26]C.mrb_value)(unsafe.Pointer(argv))[:argc:argc]
+}
+
+// newException creates an exception of the named class, such as
+// "ArgumentError", with the given message. This is meant to be returned
+// as the exception from a Func.
+func (m *Mrb) newException(class string, format string, args ...interface{}) *MrbValue {
+	msg := m.StringValue(fmt.Sprintf(format, args...))
+	exc := C.mrb_exc_new_str(m.state, m.Class(class, nil).class, msg.value)
+	return newValue(m.state, exc)
 }
 
 func checkException(state *C.mrb_state) error {
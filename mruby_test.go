@@ -1,8 +1,10 @@
 package mruby
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +47,72 @@ func TestMrbClass(t *testing.T) {
 	}
 }
 
+func TestMrbLookupClass(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	_, err := mrb.LoadString(`
+		module Foo
+			class Bar
+				class Baz; end
+			end
+		end
+		NOT_A_CLASS = 1
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, path := range []string{"Object", "Foo::Bar", "::Foo::Bar::Baz"} {
+		class, err := mrb.LookupClass(path)
+		if err != nil {
+			t.Fatalf("%s: err: %s", path, err)
+		}
+
+		name := class.MrbValue(mrb).String()
+		if name != strings.TrimPrefix(path, "::") {
+			t.Fatalf("bad: %s", name)
+		}
+	}
+
+	cases := map[string]string{
+		"Nope":        "NameError",
+		"Foo::Nope":   "NameError",
+		"Foo":         "TypeError",
+		"NOT_A_CLASS": "TypeError",
+	}
+	for path, expected := range cases {
+		_, err := mrb.LookupClass(path)
+		exc, ok := err.(*Exception)
+		if !ok {
+			t.Fatalf("%s: bad: %#v", path, err)
+		}
+		if name := exc.Class().MrbValue(mrb).String(); name != expected {
+			t.Fatalf("%s: bad: %s", path, name)
+		}
+	}
+}
+
+func TestMrbLookupModule(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	module, err := mrb.LookupModule("Kernel")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if module.MrbValue(mrb).String() != "Kernel" {
+		t.Fatalf("bad: %s", module.MrbValue(mrb))
+	}
+
+	if _, err := mrb.LookupModule("Object"); err == nil {
+		t.Fatal("should error")
+	}
+	if _, err := mrb.LookupModule("Nope"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestMrbConstDefined(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -59,6 +127,42 @@ func TestMrbConstDefined(t *testing.T) {
 	}
 }
 
+func TestMrbConstDefined_badScope(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	// Scopes that aren't classes or modules are never asked
+	scopes := []Value{mrb.FixnumValue(1), String("Object"), mrb.NilValue(), mrb.TopSelf()}
+	for _, scope := range scopes {
+		if mrb.ConstDefined("Object", scope) {
+			t.Fatalf("should not be defined in %s", scope.MrbValue(mrb))
+		}
+	}
+
+	// A nil scope is Object, and modules are scopes too
+	if !mrb.ConstDefined("Object", nil) {
+		t.Fatal("Object should be defined")
+	}
+	module := mrb.DefineModule("Hello")
+	module.DefineConst("FOO", Int(1))
+	if !mrb.ConstDefined("FOO", module) {
+		t.Fatal("FOO should be defined")
+	}
+}
+
+func TestMrbClass_missing(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	defer func() {
+		if _, ok := recover().(*Exception); !ok {
+			t.Fatal("should panic with an *Exception")
+		}
+	}()
+
+	mrb.Class("Nope", nil)
+}
+
 func TestMrbDefineClass(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -98,6 +202,79 @@ func TestMrbDefineClass_methodException(t *testing.T) {
 	}
 }
 
+func TestMrbDefineClass_conflict(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	if _, err := mrb.LoadString(`Hello = 1`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	defer func() {
+		exc, ok := recover().(*Exception)
+		if !ok {
+			t.Fatal("should panic with an *Exception")
+		}
+		if exc.Class().MrbValue(mrb).String() != "TypeError" {
+			t.Fatalf("bad: %s", exc)
+		}
+	}()
+
+	mrb.DefineClass("Hello", nil)
+}
+
+func TestMrbTryDefineClass(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	if _, err := mrb.LoadString(`Hello = 1`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	outer, err := mrb.TryDefineModule("Outer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := mrb.TryDefineClassUnder("Inner", nil, outer); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := mrb.LoadString(`Outer::Inner.new`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := map[string]func() (*Class, error){
+		"TryDefineClass": func() (*Class, error) {
+			return mrb.TryDefineClass("Hello", nil)
+		},
+		"TryDefineModule": func() (*Class, error) {
+			return mrb.TryDefineModule("Hello")
+		},
+		"TryDefineClassUnder": func() (*Class, error) {
+			return mrb.TryDefineClassUnder("Inner", mrb.Class("String", nil), outer)
+		},
+		"TryDefineModuleUnder": func() (*Class, error) {
+			return mrb.TryDefineModuleUnder("Inner", outer)
+		},
+		"TrySingletonClass": func() (*Class, error) {
+			return mrb.FixnumValue(1).TrySingletonClass()
+		},
+	}
+
+	for name, f := range cases {
+		class, err := f()
+		if err == nil {
+			t.Fatalf("%s: should error", name)
+		}
+		if class != nil {
+			t.Fatalf("%s: bad: %#v", name, class)
+		}
+		exc, ok := err.(*Exception)
+		if !ok || exc.Class().MrbValue(mrb).String() != "TypeError" {
+			t.Fatalf("%s: bad: %#v", name, err)
+		}
+	}
+}
+
 func TestMrbDefineClassUnder(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -171,6 +348,103 @@ func TestMrbFixnumValue(t *testing.T) {
 	}
 }
 
+func TestMrbStringValue(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value := mrb.StringValue("foo\x00bar\xff")
+	if value.Type() != TypeString {
+		t.Fatalf("should be string")
+	}
+
+	length, err := value.Call("bytesize")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if length.Fixnum() != 8 {
+		t.Fatalf("bad: %d", length.Fixnum())
+	}
+	if value.String() != "foo\x00bar\xff" {
+		t.Fatalf("bad: %q", value.String())
+	}
+
+	if mrb.StringValue("").String() != "" {
+		t.Fatal("should be empty")
+	}
+}
+
+func TestMrbBytesValue(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	input := []byte{'a', 0, 0xc3, 0x28}
+	value := mrb.BytesValue(input)
+	input[0] = 'b'
+
+	if !bytes.Equal(value.Bytes(), []byte{'a', 0, 0xc3, 0x28}) {
+		t.Fatalf("bad: %#v", value.Bytes())
+	}
+
+	if len(mrb.BytesValue(nil).Bytes()) != 0 {
+		t.Fatal("should be empty")
+	}
+}
+
+func TestMrbInt64Value(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.Int64Value(-42)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Type() != TypeFixnum {
+		t.Fatalf("should be fixnum")
+	}
+	if value.Int64() != -42 {
+		t.Fatalf("bad: %d", value.Int64())
+	}
+
+	// Depending on how mruby was built this may or may not fit, but it
+	// must never be silently truncated.
+	big := int64(1)<<62 + 1
+	value, err = mrb.Int64Value(big)
+	if err == nil && value.Int64() != big {
+		t.Fatalf("silently truncated: %d", value.Int64())
+	}
+}
+
+func TestMrbFixnumValue_overflow(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	big := int64(1) << 62
+	if _, err := mrb.Int64Value(big); err == nil {
+		t.Skip("mruby is built with 64-bit integers")
+	}
+
+	value := mrb.FixnumValue(int(big))
+	if value.Type() != TypeFloat {
+		t.Fatalf("should overflow into a float: %v", value.Type())
+	}
+	if value.Float() != float64(big) {
+		t.Fatalf("bad: %f", value.Float())
+	}
+}
+
+func TestMrbFloatValue(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value := mrb.FloatValue(2.5)
+	if value.Type() != TypeFloat {
+		t.Fatalf("should be float")
+	}
+	if value.Float() != 2.5 {
+		t.Fatalf("bad: %f", value.Float())
+	}
+}
+
 func TestMrbFullGC(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -303,6 +577,54 @@ func TestMrbGetArgs(t *testing.T) {
 	}
 }
 
+func TestMrbBlock(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var sum int64
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		if !m.BlockGiven() {
+			return Bool(false), nil
+		}
+
+		block, ok := m.Block()
+		if !ok {
+			return nil, m.newException("RuntimeError", "no block")
+		}
+
+		// GetArgs has the block at the end
+		args := m.GetArgs()
+		for _, arg := range args[:len(args)-1] {
+			result, err := block.Proc().Call(arg)
+			if err != nil {
+				return nil, err.(*Exception).MrbValue
+			}
+
+			sum += result.Int64()
+		}
+
+		return Bool(true), nil
+	}
+	mrb.TopSelf().SingletonClass().DefineMethod("each_double", cb, ArgsAny())
+
+	value, err := mrb.LoadString(`each_double(1, 2, 3) { |x| x * 2 }`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !value.Bool() || sum != 12 {
+		t.Fatalf("bad: %s %d", value, sum)
+	}
+
+	// A proc passed as an argument is not a block
+	value, err = mrb.LoadString(`each_double(1, Proc.new { |x| x })`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Bool() {
+		t.Fatal("should not have a block")
+	}
+}
+
 func TestMrbGlobalVariable(t *testing.T) {
 	const (
 		TestValue = "HELLO"
@@ -361,7 +683,9 @@ func TestMrbInstanceVariable(t *testing.T) {
 	if value.String() != GoldenRetriever {
 		t.Fatalf("wrong value for Dog.@breed. expected: '%s', found: '%s'", GoldenRetriever, value.String())
 	}
-	inst.SetInstanceVariable("@breed", mrb.StringValue(Husky))
+	if err := inst.SetInstanceVariable("@breed", mrb.StringValue(Husky)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
 	value = inst.GetInstanceVariable("@breed")
 	if value.String() != Husky {
 		t.Fatalf("wrong value for Dog.@breed. expected: '%s', found: '%s'", Husky, value.String())
@@ -440,6 +764,56 @@ func TestMrbRaise(t *testing.T) {
 	}
 }
 
+func TestMrbUserData(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	type key struct{}
+	mrb.SetUserData(key{}, "host")
+
+	var got interface{}
+	var same bool
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		got = m.UserData(key{})
+		same = m == mrb && self.Mrb() == mrb
+		return nil, nil
+	}
+
+	class := mrb.DefineClass("Hello", nil)
+	class.DefineClassMethod("foo", cb, ArgsNone())
+	if _, err := mrb.LoadString("Hello.foo"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if got != "host" {
+		t.Fatalf("bad: %#v", got)
+	}
+	if !same {
+		t.Fatal("callback should get the same *Mrb")
+	}
+
+	mrb.SetUserData(key{}, nil)
+	if v := mrb.UserData(key{}); v != nil {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestMrbValueMrb(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`{"a" => [1]}`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Mrb() != mrb {
+		t.Fatal("value should return the same *Mrb")
+	}
+	if value.Class().MrbValue(mrb).Mrb() != mrb {
+		t.Fatal("class should return the same *Mrb")
+	}
+}
+
 func TestMrbYield(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -662,3 +1036,26 @@ func TestMrbStackedException(t *testing.T) {
 
 	mrb.Close()
 }
+
+func TestMrbGlobalVariables(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	mrb.SetGlobalVariable("$a", Int(1))
+	if _, err := mrb.LoadString(`$b = 2`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	found := make(map[string]bool)
+	for _, name := range mrb.GlobalVariables() {
+		if name[0] != '$' {
+			t.Fatalf("bad: %s", name)
+		}
+
+		found[name] = true
+	}
+
+	if !found["$a"] || !found["$b"] {
+		t.Fatalf("bad: %#v", found)
+	}
+}
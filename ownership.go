@@ -0,0 +1,164 @@
+package mruby
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// #include "gomruby.h"
+import "C"
+
+// Options are settings for a new Mrb. See NewMrbWithOptions.
+type Options struct {
+	// CheckOwnership makes every entry point into the VM (LoadString,
+	// Run, Call, Yield, Class.New, defining classes and methods, variable
+	// access, Hash and Array operations, etc.) verify that no other
+	// goroutine is currently inside the VM. If one is, the entry panics
+	// with the call sites of both goroutines instead of corrupting memory.
+	//
+	// Re-entering the VM from the same goroutine, such as calling
+	// LoadString from within a Func, is allowed. A VM may also be handed
+	// from one goroutine to another (for example with a Pool) as long as
+	// the uses don't overlap.
+	//
+	// This is expensive and meant for debugging. It can be enabled for all
+	// VMs by building with the "mruby_checkownership" build tag.
+	CheckOwnership bool
+
+	// HandleLeaks is called by Close if any handles from MrbValue.Pin
+	// were never released, with the file and line where each one was
+	// pinned. This helps track down values that are kept alive forever.
+	HandleLeaks func(sites []string)
+}
+
+// checkOwnershipDefault is set by the mruby_checkownership build tag.
+var checkOwnershipDefault bool
+
+// ownershipChecks is the number of VMs with ownership checks enabled. If
+// it is zero, entering a VM doesn't need to look anything up.
+var ownershipChecks int32
+
+// ownershipTable is the lookup table of VMs with ownership checks
+// enabled. This is cleaned up by Mrb.Close.
+var ownershipTable = struct {
+	Map   map[*C.mrb_state]*ownership
+	Mutex *sync.Mutex
+}{
+	Map:   make(map[*C.mrb_state]*ownership),
+	Mutex: new(sync.Mutex),
+}
+
+// ownership tracks which goroutine is currently inside a VM.
+type ownership struct {
+	lock      sync.Mutex
+	goroutine uint64
+	depth     int
+	entry     string
+	caller    string
+}
+
+func noopExit() {}
+
+func registerOwnership(s *C.mrb_state) {
+	ownershipTable.Mutex.Lock()
+	ownershipTable.Map[s] = new(ownership)
+	ownershipTable.Mutex.Unlock()
+
+	atomic.AddInt32(&ownershipChecks, 1)
+}
+
+func unregisterOwnership(s *C.mrb_state) {
+	ownershipTable.Mutex.Lock()
+	_, ok := ownershipTable.Map[s]
+	delete(ownershipTable.Map, s)
+	ownershipTable.Mutex.Unlock()
+
+	if ok {
+		atomic.AddInt32(&ownershipChecks, -1)
+	}
+}
+
+// enterState marks the calling goroutine as being inside the VM and
+// returns a function to call when it leaves. It is meant to be used as:
+//
+//	defer enterState(state)()
+//
+// This does nothing unless ownership checks are enabled for the VM.
+func enterState(s *C.mrb_state) func() {
+	if atomic.LoadInt32(&ownershipChecks) == 0 {
+		return noopExit
+	}
+
+	ownershipTable.Mutex.Lock()
+	o := ownershipTable.Map[s]
+	ownershipTable.Mutex.Unlock()
+	if o == nil {
+		return noopExit
+	}
+
+	// Skip enterState itself to get the entry point and its caller.
+	entry, caller := callSite(2), callSite(3)
+	g := goroutineID()
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.depth > 0 && o.goroutine != g {
+		panic(fmt.Sprintf(
+			"mruby: Mrb used from two goroutines at the same time\n\n"+
+				"goroutine %d called %s at %s\n"+
+				"while goroutine %d is still inside %s called at %s",
+			g, entry, caller, o.goroutine, o.entry, o.caller))
+	}
+
+	if o.depth == 0 {
+		o.goroutine = g
+		o.entry = entry
+		o.caller = caller
+	}
+	o.depth++
+
+	return func() {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+
+		o.depth--
+		if o.depth == 0 {
+			o.goroutine = 0
+		}
+	}
+}
+
+// callSite returns a description of the function skip frames above the
+// caller of callSite.
+func callSite(skip int) string {
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+
+	name := "unknown"
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		name = fn.Name()
+	}
+
+	return fmt.Sprintf("%s (%s:%d)", name, file, line)
+}
+
+// goroutineID returns the ID of the current goroutine. Go deliberately
+// doesn't expose this, so it is parsed out of the stack trace header,
+// which looks like "goroutine 42 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
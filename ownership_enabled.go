@@ -0,0 +1,8 @@
+//go:build mruby_checkownership
+// +build mruby_checkownership
+
+package mruby
+
+func init() {
+	checkOwnershipDefault = true
+}
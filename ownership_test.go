@@ -0,0 +1,112 @@
+package mruby
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckOwnership(t *testing.T) {
+	mrb := NewMrbWithOptions(Options{CheckOwnership: true})
+	defer mrb.Close()
+
+	var recovered interface{}
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		// Entering again from the same goroutine is fine
+		if _, err := m.LoadString(`1 + 1`); err != nil {
+			t.Errorf("err: %s", err)
+		}
+
+		// Entering from another goroutine while we're inside is not
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() {
+				recovered = recover()
+			}()
+
+			mrb.LoadString(`2 + 2`)
+		}()
+		<-done
+
+		return nil, nil
+	}
+
+	mrb.TopSelf().SingletonClass().DefineMethod("foo", cb, ArgsNone())
+	if _, err := mrb.LoadString(`foo`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	msg, ok := recovered.(string)
+	if !ok {
+		t.Fatalf("expected panic, got: %#v", recovered)
+	}
+	if !strings.Contains(msg, "two goroutines") {
+		t.Fatalf("bad: %s", msg)
+	}
+	if !strings.Contains(msg, "ownership_test.go") {
+		t.Fatalf("should point at the call sites: %s", msg)
+	}
+}
+
+func TestCheckOwnership_handoff(t *testing.T) {
+	mrb := NewMrbWithOptions(Options{CheckOwnership: true})
+	defer mrb.Close()
+
+	if _, err := mrb.LoadString(`1`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Using the VM from another goroutine once we're done with it is ok
+	errCh := make(chan error)
+	go func() {
+		_, err := mrb.LoadString(`2`)
+		errCh <- err
+	}()
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestCheckOwnership_entryPoints(t *testing.T) {
+	mrb := NewMrbWithOptions(Options{CheckOwnership: true})
+	defer mrb.Close()
+
+	class := mrb.DefineClass("Hello", nil)
+	value := mrb.StringValue("hello")
+
+	entries := map[string]func(){
+		"DefineMethod": func() { class.DefineMethod("foo", nil, ArgsNone()) },
+		"GetConst":     func() { class.GetConst("FOO") },
+		"ClassVar":     func() { class.ClassVariable("@@foo") },
+		"Intern":       func() { mrb.Intern("foo") },
+		"SetIvar":      func() { value.SetInstanceVariable("@foo", value) },
+		"Pin":          func() { value.Pin() },
+		"Globals":      func() { mrb.GlobalVariables() },
+	}
+
+	var failures []string
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		for name, entry := range entries {
+			done := make(chan interface{})
+			go func() {
+				defer func() { done <- recover() }()
+				entry()
+			}()
+
+			if r := <-done; r == nil {
+				failures = append(failures, name)
+			}
+		}
+
+		return nil, nil
+	}
+
+	mrb.TopSelf().SingletonClass().DefineMethod("foo", cb, ArgsNone())
+	if _, err := mrb.LoadString(`foo`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(failures) > 0 {
+		t.Fatalf("should have panicked: %v", failures)
+	}
+}
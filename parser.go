@@ -44,6 +44,8 @@ func (p *Parser) Close() {
 // GenerateCode takes all the internal parser state and generates
 // executable Ruby code, returning the callable proc.
 func (p *Parser) GenerateCode() *MrbValue {
+	defer enterState(p.mrb.state)()
+
 	proc := C.mrb_generate_code(p.mrb.state, p.parser)
 	return newValue(p.mrb.state, C.mrb_obj_value(unsafe.Pointer(proc)))
 }
@@ -53,6 +55,8 @@ func (p *Parser) GenerateCode() *MrbValue {
 //
 // The CompileContext can be nil to not set a context.
 func (p *Parser) Parse(code string, c *CompileContext) ([]*ParserMessage, error) {
+	defer enterState(p.mrb.state)()
+
 	// We set p.code so that the string doesn't get garbage collected
 	var s *C.char = C.CString(code)
 	p.code = code
@@ -0,0 +1,277 @@
+package mruby
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned by Pool.Get when the pool has been closed.
+var ErrPoolClosed = errors.New("mruby: pool is closed")
+
+// PoolConfig configures a Pool.
+type PoolConfig struct {
+	// Size is the number of VMs that the pool manages. It must be
+	// greater than zero.
+	Size int
+
+	// Init is called for every VM that the pool creates, before it is
+	// handed out for the first time. This is where classes should be
+	// defined and libraries loaded. If Init returns an error, the VM is
+	// closed and the error is returned to the caller that caused the VM
+	// to be created. Init may be nil.
+	Init func(*Mrb) error
+
+	// MaxUses is the number of times a VM is handed out by Get before it
+	// is closed and replaced with a fresh one. Zero means a VM is reused
+	// until an uncaught exception occurs.
+	MaxUses int
+}
+
+// PoolStats are metrics for a Pool. See Pool.Stats.
+type PoolStats struct {
+	Size  int // Size is the configured number of VMs
+	Idle  int // Idle is the number of VMs waiting in the pool
+	InUse int // InUse is the number of VMs that were handed out by Get
+
+	Gets     uint64 // Gets is the number of successful calls to Get
+	Waits    uint64 // Waits is the number of Gets that had to wait for a VM
+	Created  uint64 // Created is the number of VMs created, including warmup
+	Recycled uint64 // Recycled is the number of VMs closed and replaced
+}
+
+// Pool is a fixed-size set of Mrb instances that can be shared between
+// goroutines.
+//
+// A single Mrb must never be used by more than one goroutine at a time.
+// Pool makes it possible to run Ruby concurrently anyways: each goroutine
+// takes a VM with Get, uses it exclusively, and gives it back with Put.
+//
+// A VM is closed and replaced with a freshly initialized one when it has
+// been handed out PoolConfig.MaxUses times, or when an exception escaped
+// from LoadString, Run or RunWithContext while it was checked out, since
+// the VM may then be in an unexpected state. Errors from Call, Yield,
+// Class.New and so on are left to the caller; callers that know a VM is
+// in a bad state can give it back with Discard instead of Put.
+type Pool struct {
+	config PoolConfig
+
+	// idle holds VMs that are ready for use. A nil entry is an empty slot
+	// whose VM failed to initialize and is created on the next Get.
+	idle chan *Mrb
+
+	lock       sync.Mutex
+	uses       map[*Mrb]int
+	checkedOut map[*Mrb]bool
+	stats      PoolStats
+	closed     bool
+}
+
+// NewPool creates a pool and initializes all of its VMs up front.
+//
+// If initializing any of the VMs fails, all of them are closed and the
+// error is returned.
+func NewPool(config PoolConfig) (*Pool, error) {
+	if config.Size <= 0 {
+		return nil, errors.New("mruby: pool size must be greater than zero")
+	}
+
+	p := &Pool{
+		config:     config,
+		idle:       make(chan *Mrb, config.Size),
+		uses:       make(map[*Mrb]int),
+		checkedOut: make(map[*Mrb]bool),
+	}
+	p.stats.Size = config.Size
+
+	for i := 0; i < config.Size; i++ {
+		m, err := p.create()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+
+		p.idle <- m
+	}
+
+	return p, nil
+}
+
+// Get takes a VM from the pool, waiting for one to be available if
+// necessary. The VM must be given back with Put once the caller is done
+// with it.
+//
+// Get returns an error if the context is done before a VM becomes
+// available, if the pool is closed, or if a new VM needs to be created
+// and its initialization fails.
+func (p *Pool) Get(ctx context.Context) (*Mrb, error) {
+	var m *Mrb
+	var ok bool
+
+	select {
+	case m, ok = <-p.idle:
+	default:
+		p.lock.Lock()
+		p.stats.Waits++
+		p.lock.Unlock()
+
+		select {
+		case m, ok = <-p.idle:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if !ok {
+		return nil, ErrPoolClosed
+	}
+
+	if m == nil {
+		var err error
+		m, err = p.create()
+		if err != nil {
+			p.release(nil)
+			return nil, err
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		delete(p.uses, m)
+		m.Close()
+		return nil, ErrPoolClosed
+	}
+
+	p.uses[m]++
+	p.checkedOut[m] = true
+	p.stats.Gets++
+	p.stats.InUse++
+	return m, nil
+}
+
+// Put gives a VM obtained from Get back to the pool. The VM must not be
+// used by the caller after this. Put panics if the VM isn't currently
+// checked out from the pool.
+func (p *Pool) Put(m *Mrb) {
+	p.put(m, false)
+}
+
+// Discard gives a VM obtained from Get back to the pool like Put, but
+// always closes it and replaces it with a fresh one.
+func (p *Pool) Discard(m *Mrb) {
+	p.put(m, true)
+}
+
+func (p *Pool) put(m *Mrb, discard bool) {
+	p.lock.Lock()
+	if !p.checkedOut[m] {
+		p.lock.Unlock()
+		panic("mruby: VM given back to the pool isn't checked out from it")
+	}
+	delete(p.checkedOut, m)
+
+	uses := p.uses[m]
+	p.stats.InUse--
+	closed := p.closed
+	recycle := discard || m.uncaught > 0 ||
+		(p.config.MaxUses > 0 && uses >= p.config.MaxUses)
+	if closed || recycle {
+		delete(p.uses, m)
+	}
+	if recycle && !closed {
+		p.stats.Recycled++
+	}
+	p.lock.Unlock()
+
+	if closed {
+		m.Close()
+		return
+	}
+
+	if recycle {
+		m.Close()
+
+		// If the replacement fails to initialize, we leave an empty slot
+		// and try again on the next Get.
+		m, _ = p.create()
+	}
+
+	p.release(m)
+}
+
+// Stats returns the current metrics for the pool.
+func (p *Pool) Stats() PoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	stats := p.stats
+	stats.Idle = len(p.idle)
+	return stats
+}
+
+// Close closes all the idle VMs in the pool. VMs that are currently in
+// use are closed when they are given back with Put. Get returns
+// ErrPoolClosed after the pool is closed.
+func (p *Pool) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.idle)
+	p.lock.Unlock()
+
+	for m := range p.idle {
+		if m != nil {
+			p.lock.Lock()
+			delete(p.uses, m)
+			p.lock.Unlock()
+			m.Close()
+		}
+	}
+}
+
+// create makes and initializes a new VM for the pool.
+func (p *Pool) create() (*Mrb, error) {
+	m := NewMrb()
+	if p.config.Init != nil {
+		if err := p.config.Init(m); err != nil {
+			m.Close()
+			return nil, err
+		}
+
+		// Exceptions during initialization were handled by Init.
+		m.uncaught = 0
+	}
+
+	p.lock.Lock()
+	p.uses[m] = 0
+	p.stats.Created++
+	p.lock.Unlock()
+
+	return m, nil
+}
+
+// release puts a VM (or an empty slot if m is nil) back into the idle
+// queue, closing it instead if the pool was closed in the meantime.
+//
+// The lock keeps Close from closing the queue during the send. The send
+// never blocks: only checked out VMs and failed slots are released, so
+// the queue always has room for them.
+func (p *Pool) release(m *Mrb) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		if m != nil {
+			delete(p.uses, m)
+			m.Close()
+		}
+
+		return
+	}
+
+	p.idle <- m
+}
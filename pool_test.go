@@ -0,0 +1,279 @@
+package mruby
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	pool, err := NewPool(PoolConfig{
+		Size: 4,
+		Init: func(m *Mrb) error {
+			class := m.DefineClass("Hello", nil)
+			class.DefineClassMethod("foo", testCallback, ArgsNone())
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			m, err := pool.Get(context.Background())
+			if err != nil {
+				errCh <- err
+				return
+			}
+			defer pool.Put(m)
+
+			value, err := m.LoadString("Hello.foo")
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if value.Fixnum() != 42 {
+				errCh <- errors.New("bad result")
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("err: %s", err)
+	}
+
+	stats := pool.Stats()
+	if stats.Gets != 100 {
+		t.Fatalf("bad gets: %d", stats.Gets)
+	}
+	if stats.Created != 4 {
+		t.Fatalf("bad created: %d", stats.Created)
+	}
+	if stats.Idle != 4 || stats.InUse != 0 {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
+
+func TestPool_maxUses(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Size: 1, MaxUses: 2})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+
+	var vms []*Mrb
+	for i := 0; i < 3; i++ {
+		m, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		vms = append(vms, m)
+		pool.Put(m)
+	}
+
+	if vms[0] != vms[1] {
+		t.Fatal("VM should be reused")
+	}
+	if vms[1] == vms[2] {
+		t.Fatal("VM should be recycled")
+	}
+	if n := pool.Stats().Recycled; n != 1 {
+		t.Fatalf("bad recycled: %d", n)
+	}
+}
+
+func TestPool_exception(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Size: 1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+
+	m, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := m.LoadString(`raise "boom"`); err == nil {
+		t.Fatal("should error")
+	}
+	pool.Put(m)
+
+	m2, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Put(m2)
+
+	if m == m2 {
+		t.Fatal("VM should be recycled after an uncaught exception")
+	}
+}
+
+func TestPool_handledException(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Size: 1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+
+	m, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// An exception from Call is left to the caller
+	if _, err := m.TopSelf().Call("raise", String("boom")); err == nil {
+		t.Fatal("should error")
+	}
+
+	// So is one that a Go function handles
+	m.KernelModule().DefineMethod("rescued", func(m *Mrb, self *MrbValue) (Value, Value) {
+		if _, err := m.LoadString(`raise "boom"`); err == nil {
+			t.Error("should error")
+		}
+		return nil, nil
+	}, ArgsNone())
+	if _, err := m.LoadString(`rescued`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	pool.Put(m)
+
+	m2, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if m != m2 {
+		t.Fatal("VM should be reused after handled exceptions")
+	}
+
+	// Discard recycles a VM even without an exception
+	pool.Discard(m2)
+
+	m3, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Put(m3)
+
+	if m3 == m2 {
+		t.Fatal("VM should be recycled after Discard")
+	}
+	if n := pool.Stats().Recycled; n != 1 {
+		t.Fatalf("bad recycled: %d", n)
+	}
+}
+
+func TestPoolPut_notCheckedOut(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Size: 2})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+
+	m, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	pool.Put(m)
+
+	mustPanic := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s should panic", name)
+			}
+		}()
+		f()
+	}
+	mustPanic("double Put", func() { pool.Put(m) })
+	mustPanic("Discard after Put", func() { pool.Discard(m) })
+
+	other := NewMrb()
+	defer other.Close()
+	mustPanic("Put of a foreign VM", func() { pool.Put(other) })
+
+	if stats := pool.Stats(); stats.InUse != 0 || stats.Idle != 2 {
+		t.Fatalf("bad stats: %#v", stats)
+	}
+
+	// The two idle VMs are still distinct
+	m1, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Put(m1)
+	m2, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Put(m2)
+	if m1 == m2 {
+		t.Fatal("the same VM was handed out twice")
+	}
+}
+
+func TestPool_initError(t *testing.T) {
+	_, err := NewPool(PoolConfig{
+		Size: 2,
+		Init: func(m *Mrb) error {
+			_, err := m.LoadString(`raise "init"`)
+			return err
+		},
+	})
+	if err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestPoolGet_context(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Size: 1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Close()
+
+	m, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer pool.Put(m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("bad: %v", err)
+	}
+	if n := pool.Stats().Waits; n != 1 {
+		t.Fatalf("bad waits: %d", n)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	pool, err := NewPool(PoolConfig{Size: 1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	m, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	pool.Close()
+	pool.Put(m)
+
+	if _, err := pool.Get(context.Background()); err != ErrPoolClosed {
+		t.Fatalf("bad: %v", err)
+	}
+}
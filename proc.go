@@ -0,0 +1,42 @@
+package mruby
+
+// #include "gomruby.h"
+import "C"
+
+// Proc represents an MrbValue that is a Proc in Ruby.
+//
+// A Proc can be obtained by calling the Proc function on MrbValue or by
+// decoding a Ruby proc with Decode. The proc is only valid for as long as
+// the underlying Ruby value is alive, so values that outlive the current
+// arena should be protected from the GC.
+type Proc struct {
+	*MrbValue
+}
+
+// Call invokes the proc with the given arguments and returns its result.
+func (p *Proc) Call(args ...Value) (*MrbValue, error) {
+	return p.MrbValue.Call("call", args...)
+}
+
+// Arity returns the number of arguments the proc takes, like Proc#arity
+// in Ruby. If the proc takes optional arguments, this is -n-1 where n is
+// the number of required arguments.
+func (p *Proc) Arity() (int, error) {
+	result, err := p.MrbValue.Call("arity")
+	if err != nil {
+		return 0, err
+	}
+
+	return int(result.Int64()), nil
+}
+
+// Lambda tells you if the proc is a lambda. Lambdas check the number of
+// arguments they are called with, and return from themselves rather than
+// from the method that created them.
+func (p *Proc) Lambda() bool {
+	if p.Type() != TypeProc {
+		return false
+	}
+
+	return C._go_mrb_proc_lambda(p.value) != 0
+}
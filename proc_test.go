@@ -0,0 +1,66 @@
+package mruby
+
+import (
+	"testing"
+)
+
+func TestProc(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	cases := []struct {
+		code   string
+		arity  int
+		lambda bool
+	}{
+		{`Proc.new { }`, 0, false},
+		{`Proc.new { |a, b| }`, 2, false},
+		{`lambda { |a| }`, 1, true},
+		{`lambda { |a, *b| }`, -2, true},
+	}
+
+	for _, tc := range cases {
+		value, err := mrb.LoadString(tc.code)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		proc := value.Proc()
+		arity, err := proc.Arity()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if arity != tc.arity {
+			t.Fatalf("%s: bad arity: %d", tc.code, arity)
+		}
+		if proc.Lambda() != tc.lambda {
+			t.Fatalf("%s: bad lambda: %v", tc.code, proc.Lambda())
+		}
+	}
+
+	if mrb.FixnumValue(1).Proc().Lambda() {
+		t.Fatal("a Fixnum is not a lambda")
+	}
+}
+
+func TestProcCall(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`lambda { |a, b| a + b }`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := value.Proc().Call(Int(1), Int(2))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Fixnum() != 3 {
+		t.Fatalf("bad: %s", result)
+	}
+
+	if _, err := value.Proc().Call(Int(1)); err == nil {
+		t.Fatal("lambda should check its arguments")
+	}
+}
@@ -0,0 +1,539 @@
+package mruby
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"unicode"
+	"unsafe"
+)
+
+// #include <stdlib.h>
+// #include "gomruby.h"
+import "C"
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// structField is a field of a Go struct exposed by DefineStructClass.
+type structField struct {
+	name  string
+	index []int
+}
+
+// DefineStructClass defines a top-level class whose instances wrap
+// pointers to Go structs of the same type as sample, which must be a
+// struct or a pointer to one. The name must not be taken by an existing
+// constant, since its instances couldn't hold a struct.
+//
+// The class gets a reader and a writer for every exported field. Like
+// with Decode, the Ruby name of a field is its lowercased Go name unless
+// it is set with the `mruby` tag, and embedded structs tagged with
+// "squash" contribute their fields directly. Values assigned from Ruby
+// are decoded into the field with the same rules as Decode.
+//
+// Exported methods of the struct, including those with pointer
+// receivers, become Ruby methods with snake_case names, such as
+// "full_name" for FullName. Arguments are decoded into the parameter
+// types and results are converted back to Ruby. If the last result is an
+// error, a non-nil error is raised as a RuntimeError. Fields take
+// precedence over methods with the same name.
+//
+// Instances created in Ruby with new start out as the zero value of the
+// struct, and new optionally takes a Hash of field values. WrapStruct
+// creates an instance from Go that wraps an existing pointer. Either way,
+// changes made in Ruby are visible to Go right away and the other way
+// around.
+func (m *Mrb) DefineStructClass(name string, sample interface{}) (*Class, error) {
+	defer enterState(m.state)()
+
+	t := reflect.TypeOf(sample)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s: sample must be a struct or a pointer to one", name)
+	}
+	if _, ok := m.structClasses[t]; ok {
+		return nil, fmt.Errorf("%s: a class is already defined for %s", name, t)
+	}
+
+	fields := structFields(t, nil)
+
+	if m.ConstDefined(name, nil) {
+		return nil, fmt.Errorf("%s: constant is already defined", name)
+	}
+
+	cs := C.CString(name)
+	defer C.free(unsafe.Pointer(cs))
+
+	value := C._go_mrb_define_class_under(
+		m.state, m.state.object_class, cs, m.state.object_class)
+	if err := checkException(m.state); err != nil {
+		return nil, err
+	}
+
+	class := newClass(m, C._go_mrb_class_ptr(value))
+	C._go_mrb_set_instance_data(class.class)
+
+	if m.structClasses == nil {
+		m.structClasses = make(map[reflect.Type]*Class)
+	}
+	m.structClasses[t] = class
+
+	defineMethod(m, class.class, "initialize", methodFunc{f: structInitialize(t, fields), spec: ArgsOpt(1)})
+
+	ptrType := reflect.PtrTo(t)
+	for i := 0; i < ptrType.NumMethod(); i++ {
+		method := ptrType.Method(i)
+		defineMethod(m, class.class, snakeCase(method.Name), methodFunc{f: structMethod(method), spec: ArgsAny()})
+	}
+
+	for _, field := range fields {
+		defineMethod(m, class.class, field.name, methodFunc{f: structFieldReader(field), spec: ArgsNone()})
+		defineMethod(m, class.class, field.name+"=", methodFunc{f: structFieldWriter(field), spec: ArgsReq(1)})
+	}
+
+	return class, nil
+}
+
+// WrapStruct returns a Ruby object wrapping ptr, which must be a pointer
+// to a struct whose type was given to DefineStructClass. The object
+// reads and writes the struct through the pointer.
+func (m *Mrb) WrapStruct(ptr interface{}) (*MrbValue, error) {
+	defer enterState(m.state)()
+
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, errors.New("WrapStruct requires a non-nil pointer")
+	}
+
+	class, ok := m.structClasses[v.Elem().Type()]
+	if !ok {
+		return nil, fmt.Errorf("no class defined for %s", v.Elem().Type())
+	}
+
+	return m.wrapStruct(class, v), nil
+}
+
+// UnwrapStruct returns the pointer to the Go struct that is wrapped by
+// the Ruby object v.
+func (m *Mrb) UnwrapStruct(v *MrbValue) (interface{}, error) {
+	defer enterState(m.state)()
+
+	ptr, ok := unwrapStruct(v)
+	if !ok {
+		return nil, errors.New("value doesn't wrap a Go struct")
+	}
+
+	return ptr.Interface(), nil
+}
+
+//export goStructFree
+func goStructFree(s *C.mrb_state, p unsafe.Pointer) {
+	if m, ok := freeingMrb(s); ok {
+		delete(m.structs, int(uintptr(p)))
+	}
+}
+
+func (m *Mrb) wrapStruct(class *Class, ptr reflect.Value) *MrbValue {
+	id := m.addStruct(ptr)
+	return newValue(m.state, C._go_mrb_struct_wrap(m.state, class.class, C.mrb_int(id)))
+}
+
+// addStruct stores a pointer to a Go struct and returns its id. Ids
+// start at 1 since 0 means that an object doesn't wrap a struct.
+func (m *Mrb) addStruct(ptr reflect.Value) int {
+	if m.structs == nil {
+		m.structs = make(map[int]reflect.Value)
+	}
+
+	m.nextStruct++
+	m.structs[m.nextStruct] = ptr
+	return m.nextStruct
+}
+
+// unwrapStruct returns the pointer to the Go struct wrapped by v.
+func unwrapStruct(v *MrbValue) (reflect.Value, bool) {
+	id := int(C._go_mrb_struct_id(v.value))
+	if id == 0 {
+		return reflect.Value{}, false
+	}
+
+	ptr, ok := lookupMrb(v.state).structs[id]
+	return ptr, ok
+}
+
+// structSelf returns the pointer wrapped by self, or an exception to
+// raise if there isn't one.
+func structSelf(m *Mrb, self *MrbValue) (reflect.Value, Value) {
+	ptr, ok := unwrapStruct(self)
+	if !ok {
+		return reflect.Value{}, m.newException("TypeError", "uninitialized Go struct")
+	}
+
+	return ptr, nil
+}
+
+// structFields returns the fields of t that are exposed to Ruby, using
+// the same names as Decode.
+func structFields(t reflect.Type, index []int) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		tagParts := strings.Split(field.Tag.Get(tagName), ",")
+		if field.Anonymous {
+			squash := false
+			for _, tag := range tagParts[1:] {
+				squash = squash || tag == "squash"
+			}
+
+			if squash && field.Type.Kind() == reflect.Struct {
+				fields = append(fields, structFields(field.Type, fieldIndex)...)
+				continue
+			}
+		}
+
+		// Unexported fields can't be set, and decodedFields is only
+		// meaningful to Decode.
+		if field.PkgPath != "" || (len(tagParts) > 1 && tagParts[1] == "decodedFields") {
+			continue
+		}
+
+		name := strings.ToLower(field.Name)
+		if tagParts[0] != "" {
+			name = tagParts[0]
+		}
+
+		fields = append(fields, structField{name: name, index: fieldIndex})
+	}
+
+	return fields
+}
+
+func structInitialize(t reflect.Type, fields []structField) Func {
+	return func(m *Mrb, self *MrbValue) (Value, Value) {
+		// Storing the struct in any other kind of object would corrupt it
+		if self.Type() != TypeData || C._go_mrb_struct_holder(self.value) == 0 {
+			return nil, m.newException("TypeError",
+				"%s can't hold a %s", self.Class().Name(), t)
+		}
+
+		args, _ := m.getArgs()
+		if len(args) > 1 {
+			return nil, m.newException("ArgumentError",
+				"wrong number of arguments (%d for 0..1)", len(args))
+		}
+
+		ptr := reflect.New(t)
+		if len(args) == 1 {
+			if args[0].Type() != TypeHash {
+				return nil, m.newException("TypeError",
+					"expected a Hash of fields, got %v", args[0].Type())
+			}
+
+			if exc := setStructFields(m, ptr, fields, args[0].Hash()); exc != nil {
+				return nil, exc
+			}
+		}
+
+		// If initialize is called again, forget the old struct
+		if id := int(C._go_mrb_struct_id(self.value)); id != 0 {
+			delete(m.structs, id)
+		}
+
+		C._go_mrb_struct_set(self.value, C.mrb_int(m.addStruct(ptr)))
+		return nil, nil
+	}
+}
+
+// setStructFields sets the fields of the struct ptr points to from a
+// Hash keyed by field name, as either strings or symbols.
+func setStructFields(m *Mrb, ptr reflect.Value, fields []structField, h *Hash) Value {
+	keys, err := h.Keys()
+	if err != nil {
+		return err.(*Exception).MrbValue
+	}
+
+	array := keys.Array()
+	for i := 0; i < array.Len(); i++ {
+		key, err := array.Get(i)
+		if err != nil {
+			return err.(*Exception).MrbValue
+		}
+
+		var field *structField
+		for j := range fields {
+			if fields[j].name == key.String() {
+				field = &fields[j]
+				break
+			}
+		}
+		if field == nil {
+			return m.newException("ArgumentError", "unknown field: %s", key)
+		}
+
+		value, err := h.Get(key)
+		if err != nil {
+			return err.(*Exception).MrbValue
+		}
+
+		var d decoder
+		if err := d.decode(field.name, value, ptr.Elem().FieldByIndex(field.index)); err != nil {
+			return m.newException("TypeError", "%s", err)
+		}
+	}
+
+	return nil
+}
+
+func structFieldReader(field structField) Func {
+	return func(m *Mrb, self *MrbValue) (Value, Value) {
+		ptr, exc := structSelf(m, self)
+		if exc != nil {
+			return nil, exc
+		}
+
+		result, err := m.goValue(ptr.Elem().FieldByIndex(field.index))
+		if err != nil {
+			return nil, m.newException("TypeError", "%s: %s", field.name, err)
+		}
+
+		return result, nil
+	}
+}
+
+func structFieldWriter(field structField) Func {
+	return func(m *Mrb, self *MrbValue) (Value, Value) {
+		ptr, exc := structSelf(m, self)
+		if exc != nil {
+			return nil, exc
+		}
+
+		args, _ := m.getArgs()
+		if len(args) != 1 {
+			return nil, m.newException("ArgumentError",
+				"wrong number of arguments (%d for 1)", len(args))
+		}
+
+		// Decode into a new value first so that the field is left alone
+		// if decoding fails halfway through.
+		target := ptr.Elem().FieldByIndex(field.index)
+		value := reflect.New(target.Type()).Elem()
+		var d decoder
+		if err := d.decode(field.name, args[0], value); err != nil {
+			return nil, m.newException("TypeError", "%s", err)
+		}
+
+		target.Set(value)
+		return args[0], nil
+	}
+}
+
+func structMethod(method reflect.Method) Func {
+	return func(m *Mrb, self *MrbValue) (Value, Value) {
+		ptr, exc := structSelf(m, self)
+		if exc != nil {
+			return nil, exc
+		}
+
+		t := method.Type
+		args, _ := m.getArgs()
+
+		// The first input is the receiver
+		numIn := t.NumIn() - 1
+		if t.IsVariadic() && len(args) < numIn-1 {
+			return nil, m.newException("ArgumentError",
+				"wrong number of arguments (%d for %d+)", len(args), numIn-1)
+		}
+		if !t.IsVariadic() && len(args) != numIn {
+			return nil, m.newException("ArgumentError",
+				"wrong number of arguments (%d for %d)", len(args), numIn)
+		}
+
+		in := make([]reflect.Value, len(args)+1)
+		in[0] = ptr
+		for i, arg := range args {
+			var argType reflect.Type
+			if t.IsVariadic() && i >= numIn-1 {
+				argType = t.In(numIn).Elem()
+			} else {
+				argType = t.In(i + 1)
+			}
+
+			in[i+1] = reflect.New(argType).Elem()
+			var d decoder
+			if err := d.decode(fmt.Sprintf("argument %d", i+1), arg, in[i+1]); err != nil {
+				return nil, m.newException("TypeError", "%s", err)
+			}
+		}
+
+		out := method.Func.Call(in)
+
+		// A trailing error is raised instead of returned
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err, _ := out[n-1].Interface().(error); err != nil {
+				return nil, m.newException("RuntimeError", "%s", err)
+			}
+
+			out = out[:n-1]
+		}
+
+		switch len(out) {
+		case 0:
+			return nil, nil
+		case 1:
+			result, err := m.goValue(out[0])
+			if err != nil {
+				return nil, m.newException("TypeError", "%s", err)
+			}
+
+			return result, nil
+		default:
+			results := make([]interface{}, len(out))
+			for i, v := range out {
+				results[i] = v.Interface()
+			}
+
+			result, err := m.goValue(reflect.ValueOf(results))
+			if err != nil {
+				return nil, m.newException("TypeError", "%s", err)
+			}
+
+			return result, nil
+		}
+	}
+}
+
+// goValue converts a Go value into a Ruby value. Structs and pointers to
+// structs with a class from DefineStructClass are wrapped rather than
+// copied whenever possible.
+func (m *Mrb) goValue(v reflect.Value) (*MrbValue, error) {
+	if !v.IsValid() {
+		return m.NilValue(), nil
+	}
+
+	if !v.CanInterface() {
+		return nil, fmt.Errorf("unexported value of type %s", v.Type())
+	}
+
+	if value, ok := v.Interface().(Value); ok {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if v.IsNil() {
+				return m.NilValue(), nil
+			}
+		}
+
+		return value.MrbValue(m), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return Bool(v.Bool()).MrbValue(m), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return m.Int64Value(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows Fixnum", v.Uint())
+		}
+
+		return m.Int64Value(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return m.FloatValue(v.Float()), nil
+	case reflect.String:
+		return m.StringValue(v.String()), nil
+	case reflect.Interface:
+		return m.goValue(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return m.NilValue(), nil
+		}
+
+		if class, ok := m.structClasses[v.Elem().Type()]; ok {
+			return m.wrapStruct(class, v), nil
+		}
+
+		return m.goValue(v.Elem())
+	case reflect.Struct:
+		class, ok := m.structClasses[v.Type()]
+		if !ok {
+			break
+		}
+
+		// Wrap the struct itself if we can so that changes are visible,
+		// otherwise wrap a copy.
+		if v.CanAddr() {
+			return m.wrapStruct(class, v.Addr()), nil
+		}
+
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		return m.wrapStruct(class, ptr), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return m.BytesValue(v.Bytes()), nil
+		}
+
+		fallthrough
+	case reflect.Array:
+		ary := C.mrb_ary_new(m.state)
+		for i := 0; i < v.Len(); i++ {
+			value, err := m.goValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+
+			C.mrb_ary_push(m.state, ary, value.value)
+		}
+
+		return newValue(m.state, ary), nil
+	case reflect.Map:
+		h := newValue(m.state, C.mrb_hash_new(m.state)).Hash()
+		for _, key := range v.MapKeys() {
+			k, err := m.goValue(key)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := m.goValue(v.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+
+			if err := h.Set(k, value); err != nil {
+				return nil, err
+			}
+		}
+
+		return h.MrbValue, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type to convert to Ruby: %s", v.Type())
+}
+
+// snakeCase converts a Go name such as "FullName" or "HTTPServer" into a
+// Ruby name such as "full_name" or "http_server".
+func snakeCase(s string) string {
+	runes := []rune(s)
+
+	var buf bytes.Buffer
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) ||
+				(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				buf.WriteByte('_')
+			}
+
+			r = unicode.ToLower(r)
+		}
+
+		buf.WriteRune(r)
+	}
+
+	return buf.String()
+}
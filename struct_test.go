@@ -0,0 +1,290 @@
+package mruby
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testStructPoint struct {
+	X, Y int
+}
+
+type testStructUser struct {
+	Name    string
+	Age     int `mruby:"years"`
+	Tags    []string
+	Home    testStructPoint
+	private int
+}
+
+func (u *testStructUser) Greet(greeting string) string {
+	return greeting + ", " + u.Name
+}
+
+func (u *testStructUser) HaveBirthday() {
+	u.Age++
+}
+
+func (u testStructUser) MustBeAdult() error {
+	if u.Age < 18 {
+		return errors.New("too young")
+	}
+
+	return nil
+}
+
+func (u *testStructUser) TagCount(extra ...string) (int, int) {
+	return len(u.Tags), len(extra)
+}
+
+func testStructVM(t *testing.T) *Mrb {
+	mrb := NewMrb()
+	if _, err := mrb.DefineStructClass("Point", testStructPoint{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := mrb.DefineStructClass("User", &testStructUser{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return mrb
+}
+
+func TestMrbDefineStructClass(t *testing.T) {
+	mrb := testStructVM(t)
+	defer mrb.Close()
+
+	user := &testStructUser{Name: "alice", Age: 17, Tags: []string{"a"}}
+	value, err := mrb.WrapStruct(user)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		code     string
+		expected string
+	}{
+		{`u.name`, "alice"},
+		{`u.years`, "17"},
+		{`u.tags.inspect`, `["a"]`},
+		{`u.greet("hi")`, "hi, alice"},
+		{`u.tag_count("x", "y").inspect`, "[1, 2]"},
+		{`u.respond_to?(:private)`, "false"},
+		{`u.home.class`, "Point"},
+	}
+
+	for _, tc := range cases {
+		proc, err := mrb.LoadString(`Proc.new { |u| ` + tc.code + ` }`)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		result, err := proc.Call("call", value)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.code, err)
+		}
+		if result.String() != tc.expected {
+			t.Fatalf("%s: bad: %s", tc.code, result)
+		}
+	}
+
+	// Changes from Ruby are visible in Go, including nested structs
+	proc, err := mrb.LoadString(`Proc.new do |u|
+		u.name = "bob"
+		u.tags = ["x", "y"]
+		u.have_birthday
+		u.home.x = 3
+	end`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := proc.Call("call", value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if user.Name != "bob" || user.Age != 18 || len(user.Tags) != 2 || user.Home.X != 3 {
+		t.Fatalf("bad: %#v", user)
+	}
+
+	// Changes from Go are visible in Ruby
+	user.Name = "carol"
+	result, err := value.Call("name")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.String() != "carol" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	ptr, err := mrb.UnwrapStruct(value)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if ptr != user {
+		t.Fatal("should unwrap to the same pointer")
+	}
+
+	var decoded *testStructUser
+	if err := Decode(&decoded, value); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if decoded != user {
+		t.Fatal("should decode to the same pointer")
+	}
+}
+
+func TestMrbDefineStructClass_new(t *testing.T) {
+	mrb := testStructVM(t)
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`User.new(name: "dave", "years" => 40)`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ptr, err := mrb.UnwrapStruct(value)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	user := ptr.(*testStructUser)
+	if user.Name != "dave" || user.Age != 40 {
+		t.Fatalf("bad: %#v", user)
+	}
+
+	value, err = mrb.LoadString(`User.new.name`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.String() != "" {
+		t.Fatalf("bad: %s", value)
+	}
+}
+
+func TestMrbDefineStructClass_errors(t *testing.T) {
+	mrb := testStructVM(t)
+	defer mrb.Close()
+
+	cases := map[string]string{
+		`User.new(nope: 1)`:       "ArgumentError",
+		`User.new(years: "x")`:    "TypeError",
+		`User.new.years = "x"`:    "TypeError",
+		`User.new.greet`:          "ArgumentError",
+		`User.new.must_be_adult`:  "RuntimeError",
+		`User.new.greet([1])`:     "TypeError",
+		`User.new.tag_count([1])`: "TypeError",
+		`User.new("name")`:        "TypeError",
+		`User.new({}, {})`:        "ArgumentError",
+	}
+
+	for code, expected := range cases {
+		_, err := mrb.LoadString(code)
+		exc, ok := err.(*Exception)
+		if !ok {
+			t.Fatalf("%s: bad: %#v", code, err)
+		}
+		if name := exc.Class().Name(); name != expected {
+			t.Fatalf("%s: bad: %s (%s)", code, name, exc)
+		}
+	}
+
+	if _, err := mrb.DefineStructClass("Bad", 1); err == nil {
+		t.Fatal("should error")
+	}
+	if _, err := mrb.DefineStructClass("Again", testStructPoint{}); err == nil {
+		t.Fatal("should error")
+	}
+	if _, err := mrb.WrapStruct(&struct{}{}); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestMrbDefineStructClass_defined(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	if _, err := mrb.LoadString(`Taken = 1`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, name := range []string{"Hash", "String", "Taken"} {
+		if _, err := mrb.DefineStructClass(name, testStructPoint{}); err == nil {
+			t.Fatalf("%s: should error", name)
+		}
+	}
+
+	// The existing classes are left alone
+	value, err := mrb.LoadString(`h = Hash.new; h[:a] = 1; h[:a]`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Fixnum() != 1 {
+		t.Fatalf("bad: %s", value)
+	}
+}
+
+func TestMrbDefineStructClass_initializeType(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	init := structInitialize(reflect.TypeOf(testStructPoint{}), nil)
+	for _, self := range []*MrbValue{
+		mrb.StringValue("foo"),
+		mrb.TopSelf(),
+	} {
+		_, exc := init(mrb, self)
+		if exc == nil {
+			t.Fatalf("%s: should error", self)
+		}
+		if name := exc.MrbValue(mrb).Class().Name(); name != "TypeError" {
+			t.Fatalf("%s: bad: %s", self, name)
+		}
+	}
+}
+
+func TestMrbDefineStructClass_gc(t *testing.T) {
+	mrb := testStructVM(t)
+	defer mrb.Close()
+
+	idx := mrb.ArenaSave()
+	for i := 0; i < 100; i++ {
+		if _, err := mrb.WrapStruct(&testStructPoint{}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	mrb.ArenaRestore(idx)
+	mrb.FullGC()
+
+	if n := len(mrb.structs); n != 0 {
+		t.Fatalf("wrapped structs should be released: %d", n)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	cases := map[string]string{
+		"Name":       "name",
+		"FullName":   "full_name",
+		"ID":         "id",
+		"UserID":     "user_id",
+		"HTTPServer": "http_server",
+	}
+
+	for input, expected := range cases {
+		if actual := snakeCase(input); actual != expected {
+			t.Fatalf("%s: bad: %s", input, actual)
+		}
+	}
+}
+
+func TestMrbValue_structString(t *testing.T) {
+	mrb := testStructVM(t)
+	defer mrb.Close()
+
+	value, err := mrb.WrapStruct(&testStructPoint{X: 1})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.HasPrefix(value.String(), "#<Point") {
+		t.Fatalf("bad: %s", value)
+	}
+}
@@ -17,6 +17,16 @@ type Value interface {
 	MrbValue(*Mrb) *MrbValue
 }
 
+// Bool is the ruby `true` or `false` value.
+type Bool bool
+
+// Bytes is a Ruby String built from raw bytes. Unlike String, it makes
+// no assumptions about the encoding of the contents.
+type Bytes []byte
+
+// Float is the basic ruby Float type.
+type Float float64
+
 // Int is the basic ruby Integer type.
 type Int int
 
@@ -26,6 +36,14 @@ type NilType [0]byte
 // String is objects of the type String.
 type String string
 
+// Symbol is the ruby Symbol type, such as `:foo`. The value is the name
+// of the symbol without the leading colon.
+type Symbol string
+
+// Sym is a symbol interned in a VM with Mrb.Intern. Unlike Symbol, it
+// is not a Value; it names methods for CallSym.
+type Sym C.mrb_sym
+
 // Nil is a constant that can be used as a Nil Value
 var Nil NilType
 
@@ -41,15 +59,22 @@ func init() {
 	Nil = [0]byte{}
 }
 
-// SetInstanceVariable sets an instance variable on this value.
-func (v *MrbValue) SetInstanceVariable(variable string, value *MrbValue) {
+// SetInstanceVariable sets an instance variable on this value. It returns
+// an error if the value can't have instance variables, such as a Fixnum,
+// or if it is frozen.
+func (v *MrbValue) SetInstanceVariable(variable string, value *MrbValue) error {
+	defer enterState(v.state)()
+
 	cs := C.CString(variable)
 	defer C.free(unsafe.Pointer(cs))
 	C._go_mrb_iv_set(v.state, v.value, C.mrb_intern_cstr(v.state, cs), value.value)
+	return checkException(v.state)
 }
 
 // GetInstanceVariable gets an instance variable on this value.
 func (v *MrbValue) GetInstanceVariable(variable string) *MrbValue {
+	defer enterState(v.state)()
+
 	cs := C.CString(variable)
 	defer C.free(unsafe.Pointer(cs))
 	return newValue(v.state, C._go_mrb_iv_get(v.state, v.value, C.mrb_intern_cstr(v.state, cs)))
@@ -74,10 +99,62 @@ func (v *MrbValue) CallBlock(method string, args ...Value) (*MrbValue, error) {
 }
 
 func (v *MrbValue) call(method string, args []Value, block Value) (*MrbValue, error) {
+	cs := C.CString(method)
+	defer C.free(unsafe.Pointer(cs))
+
+	return v.callSym(C.mrb_intern_cstr(v.state, cs), args, block)
+}
+
+// CallSym is the same as Call except that the method is named by a
+// symbol from Mrb.Intern. This saves copying and interning the name on
+// every call, so it is the better choice in hot loops.
+//
+// With up to three arguments, the only allocation CallSym makes is the
+// returned *MrbValue. Arguments that are already *MrbValues are passed
+// as they are, while other Values, such as Int or String, are converted
+// to a new *MrbValue first, which allocates.
+func (v *MrbValue) CallSym(method Sym, args ...Value) (*MrbValue, error) {
+	if len(args) > maxFastArgs {
+		return v.callSym(C.mrb_sym(method), args, nil)
+	}
+
+	defer enterState(v.state)()
+
+	mrb := lookupMrb(v.state)
+
+	// The arguments are passed to C by value, so they stay on the stack
+	var argv [maxFastArgs]C.mrb_value
+	for i, arg := range args {
+		argv[i] = arg.MrbValue(mrb).value
+	}
+
+	result := C._go_mrb_call3(
+		v.state,
+		v.value,
+		C.mrb_sym(method),
+		C.mrb_int(len(args)),
+		argv[0],
+		argv[1],
+		argv[2])
+
+	if exc := checkException(v.state); exc != nil {
+		return nil, exc
+	}
+
+	return newValue(v.state, result), nil
+}
+
+// maxFastArgs is the most arguments CallSym passes to C without an
+// argument list. See _go_mrb_call3.
+const maxFastArgs = 3
+
+func (v *MrbValue) callSym(method C.mrb_sym, args []Value, block Value) (*MrbValue, error) {
+	defer enterState(v.state)()
+
 	var argv []C.mrb_value
 	var argvPtr *C.mrb_value
 
-	mrb := &Mrb{v.state}
+	mrb := lookupMrb(v.state)
 
 	if len(args) > 0 {
 		// Make the raw byte slice to hold our arguments we'll pass to C
@@ -95,15 +172,16 @@ func (v *MrbValue) call(method string, args []Value, block Value) (*MrbValue, er
 		blockV = &val
 	}
 
-	cs := C.CString(method)
-	defer C.free(unsafe.Pointer(cs))
+	if len(argv) > maxFuncallArgs {
+		return mrb.sendSplat(v.value, method, argv, blockV)
+	}
 
 	// If we have a block, we have to call a separate function to
 	// pass a block in. Otherwise, we just call it directly.
 	result := C._go_mrb_call(
 		v.state,
 		v.value,
-		C.mrb_intern_cstr(v.state, cs),
+		method,
 		C.mrb_int(len(argv)),
 		argvPtr,
 		blockV)
@@ -115,6 +193,59 @@ func (v *MrbValue) call(method string, args []Value, block Value) (*MrbValue, er
 	return newValue(v.state, result), nil
 }
 
+// maxFuncallArgs is the most arguments that mruby can pass to a method
+// called from C. See sendSplat.
+var maxFuncallArgs = int(C._go_get_max_funcall_args())
+
+// splatSenderName is the hidden root (see setHiddenRoot) that keeps the
+// lambda used by sendSplat alive.
+const splatSenderName = "__gomruby_splat_sender__"
+
+// sendSplat calls a method with more arguments than mruby allows from C
+// (MRB_FUNCALL_ARGC_MAX). The arguments are packed into an array that
+// Ruby code then splats into the call, which has no such limit.
+func (m *Mrb) sendSplat(recv C.mrb_value, method C.mrb_sym, argv []C.mrb_value, block *C.mrb_value) (*MrbValue, error) {
+	if m.splatSender == nil {
+		code := C.CString(`lambda { |recv, mid, args, blk| recv.__send__(mid, *args, &blk) }`)
+		defer C.free(unsafe.Pointer(code))
+
+		sender := C._go_mrb_load_string(m.state, code)
+		if err := checkException(m.state); err != nil {
+			return nil, err
+		}
+
+		if err := m.setHiddenRoot(splatSenderName, sender); err != nil {
+			return nil, err
+		}
+		m.splatSender = newValue(m.state, sender)
+	}
+
+	blockV := C.mrb_nil_value()
+	if block != nil {
+		blockV = *block
+	}
+
+	args := [4]C.mrb_value{
+		recv,
+		C.mrb_symbol_value(method),
+		C.mrb_ary_new_from_values(m.state, C.mrb_int(len(argv)), &argv[0]),
+		blockV,
+	}
+
+	result := C._go_mrb_call(
+		m.state,
+		m.splatSender.value,
+		C.mrb_sym(m.Intern("call")),
+		C.mrb_int(len(args)),
+		&args[0],
+		nil)
+	if exc := checkException(m.state); exc != nil {
+		return nil, exc
+	}
+
+	return newValue(m.state, result), nil
+}
+
 // IsDead tells you if an object has been collected by the GC or not.
 func (v *MrbValue) IsDead() bool {
 	return C.ushort(C._go_isdead(v.state, v.value)) != 0
@@ -127,10 +258,11 @@ func (v *MrbValue) MrbValue(*Mrb) *MrbValue {
 
 // Mrb returns the Mrb state for this value.
 func (v *MrbValue) Mrb() *Mrb {
-	return &Mrb{v.state}
+	return lookupMrb(v.state)
 }
 
-// GCProtect protects this value from being garbage collected.
+// GCProtect protects this value from being garbage collected until the
+// arena is restored. To keep a value alive across calls, use Pin.
 func (v *MrbValue) GCProtect() {
 	C.mrb_gc_protect(v.state, v.value)
 }
@@ -173,6 +305,19 @@ func (e *Exception) String() string {
 // Type conversions to Go types
 //-------------------------------------------------------------------
 
+// Bool returns true if this value is `true`. Any other value, including
+// truthy values such as numbers and strings, returns false. Use Truthy
+// to test a value the way a Ruby conditional would.
+func (v *MrbValue) Bool() bool {
+	return v.Type() == TypeTrue
+}
+
+// Truthy returns whether this value is considered true by Ruby. Only
+// `nil` and `false` are not truthy.
+func (v *MrbValue) Truthy() bool {
+	return C._go_mrb_test(v.value) != 0
+}
+
 // Array returns the Array value of this value. If the Type of the MrbValue
 // is not a TypeArray, then this will panic. If the MrbValue has a
 // `to_a` function, you must call that manually prior to calling this
@@ -184,10 +329,21 @@ func (v *MrbValue) Array() *Array {
 // Fixnum returns the numeric value of this object if the Type() is
 // TypeFixnum. Calling this with any other type will result in undefined
 // behavior.
+//
+// If mruby is built with 64-bit integers and Go's int is 32 bits, the
+// value is truncated. Use Int64 to always get the full value.
 func (v *MrbValue) Fixnum() int {
 	return int(C._go_mrb_fixnum(v.value))
 }
 
+// Int64 returns the numeric value of this object if the Type() is
+// TypeFixnum. Unlike Fixnum, this is never truncated no matter how mruby
+// was configured. Calling this with any other type will result in
+// undefined behavior.
+func (v *MrbValue) Int64() int64 {
+	return int64(C._go_mrb_fixnum(v.value))
+}
+
 // Float returns the numeric value of this object if the Type() is
 // TypeFloat. Calling this with any other type will result in undefined
 // behavior.
@@ -203,31 +359,251 @@ func (v *MrbValue) Hash() *Hash {
 	return &Hash{v}
 }
 
-// String returns the "to_s" result of this value.
+// Proc returns the Proc value of this value. If the Type of the MrbValue
+// is not a TypeProc, then calling methods on the result will fail.
+func (v *MrbValue) Proc() *Proc {
+	return &Proc{v}
+}
+
+// Bytes returns the raw bytes of the "to_s" result of this value. Ruby
+// strings are binary-safe, so the result may contain NUL bytes or bytes
+// that are not valid UTF-8.
+func (v *MrbValue) Bytes() []byte {
+	defer enterState(v.state)()
+
+	value := C._go_mrb_obj_as_string(v.state, v.value)
+	return C.GoBytes(
+		unsafe.Pointer(C._go_RSTRING_PTR(value)),
+		C.int(C._go_RSTRING_LEN(value)))
+}
+
+// String returns the "to_s" result of this value. Like Bytes, this
+// keeps the full contents of the string even if it contains NUL bytes.
+// If to_s raises, the default representation from Object#to_s is
+// returned instead. Use ToS to get the exception as an error.
 func (v *MrbValue) String() string {
-	value := C.mrb_obj_as_string(v.state, v.value)
-	result := C.GoString(C.mrb_string_value_ptr(v.state, value))
-	return result
+	defer enterState(v.state)()
+
+	value := C._go_mrb_obj_as_string(v.state, v.value)
+	return C.GoStringN(
+		C._go_RSTRING_PTR(value),
+		C.int(C._go_RSTRING_LEN(value)))
+}
+
+// ToS is like String, but returns the exception as an error if to_s
+// raises.
+func (v *MrbValue) ToS() (string, error) {
+	defer enterState(v.state)()
+
+	value := C._go_mrb_obj_to_s(v.state, v.value)
+	if err := checkException(v.state); err != nil {
+		return "", err
+	}
+
+	return C.GoStringN(
+		C._go_RSTRING_PTR(value),
+		C.int(C._go_RSTRING_LEN(value))), nil
 }
 
 // Class returns the *Class of a value.
 func (v *MrbValue) Class() *Class {
-	mrb := &Mrb{v.state}
+	mrb := lookupMrb(v.state)
 	return newClass(mrb, C.mrb_class(v.state, v.value))
 }
 
 // SingletonClass returns the singleton class (a class isolated just for the
-// scope of the object) for the given value.
+// scope of the object) for the given value. Values that can't have a
+// singleton class, such as a Fixnum or Symbol, panic with the TypeError
+// as an *Exception. Use TrySingletonClass to get an error instead.
 func (v *MrbValue) SingletonClass() *Class {
-	mrb := &Mrb{v.state}
-	sclass := C._go_mrb_class_ptr(C.mrb_singleton_class(v.state, v.value))
-	return newClass(mrb, sclass)
+	return mustClass(v.TrySingletonClass())
+}
+
+// TrySingletonClass is like SingletonClass, but returns the TypeError as
+// an *Exception instead of panicking.
+func (v *MrbValue) TrySingletonClass() (*Class, error) {
+	defer enterState(v.state)()
+
+	mrb := lookupMrb(v.state)
+	return mrb.classResult(C._go_mrb_singleton_class(v.state, v.value))
+}
+
+// Extend adds the methods of the module to this value only, like
+// "extend" in Ruby.
+func (v *MrbValue) Extend(module *Class) error {
+	_, err := v.Call("extend", module.MrbValue(lookupMrb(v.state)))
+	return err
+}
+
+// Inspect returns the "inspect" result of this value.
+func (v *MrbValue) Inspect() (string, error) {
+	result, err := v.Call("inspect")
+	if err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+// RespondTo tells you if this value responds to the given method.
+func (v *MrbValue) RespondTo(method string) (bool, error) {
+	result, err := v.Call("respond_to?", Symbol(method))
+	if err != nil {
+		return false, err
+	}
+
+	return result.Truthy(), nil
+}
+
+// IsA tells you if this value is an instance of the class, one of its
+// subclasses, or includes the module.
+func (v *MrbValue) IsA(c *Class) (bool, error) {
+	result, err := v.Call("is_a?", c.MrbValue(lookupMrb(v.state)))
+	if err != nil {
+		return false, err
+	}
+
+	return result.Truthy(), nil
+}
+
+// InstanceVariables returns the instance variables of this value, keyed
+// by name including the leading "@".
+func (v *MrbValue) InstanceVariables() (map[string]*MrbValue, error) {
+	names, err := v.Call("instance_variables")
+	if err != nil {
+		return nil, err
+	}
+
+	array := names.Array()
+	result := make(map[string]*MrbValue, array.Len())
+	for i := 0; i < array.Len(); i++ {
+		name, err := array.Get(i)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := v.Call("instance_variable_get", name)
+		if err != nil {
+			return nil, err
+		}
+
+		result[name.String()] = value
+	}
+
+	return result, nil
+}
+
+// Methods returns the names of the public methods of this value.
+func (v *MrbValue) Methods() ([]string, error) {
+	result, err := v.Call("methods")
+	if err != nil {
+		return nil, err
+	}
+
+	return stringSlice(result)
+}
+
+// ObjectID returns the object_id of this value.
+func (v *MrbValue) ObjectID() int64 {
+	return int64(C.mrb_obj_id(v.value))
+}
+
+// Frozen tells you if this value is frozen. mruby 1.2, which this
+// package builds against by default, has no frozen? method, so this
+// returns a NoMethodError as an *Exception there.
+func (v *MrbValue) Frozen() (bool, error) {
+	result, err := v.Call("frozen?")
+	if err != nil {
+		return false, err
+	}
+
+	return result.Truthy(), nil
+}
+
+// Freeze freezes this value so that it can no longer be modified. Like
+// Frozen, this returns a NoMethodError on mruby 1.2, which has no freeze.
+func (v *MrbValue) Freeze() error {
+	_, err := v.Call("freeze")
+	return err
+}
+
+// Equal tells you if this value is equal to other using "==".
+func (v *MrbValue) Equal(other Value) (bool, error) {
+	result, err := v.Call("==", other)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Truthy(), nil
+}
+
+// Eql tells you if this value is equal to other using "eql?", which is
+// how Hash compares keys.
+func (v *MrbValue) Eql(other Value) (bool, error) {
+	result, err := v.Call("eql?", other)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Truthy(), nil
+}
+
+// HashCode returns the "hash" result of this value.
+func (v *MrbValue) HashCode() (int64, error) {
+	result, err := v.Call("hash")
+	if err != nil {
+		return 0, err
+	}
+	if result.Type() != TypeFixnum {
+		return 0, fmt.Errorf("hash returned a non-Fixnum (%v)", result.Type())
+	}
+
+	return result.Int64(), nil
+}
+
+// stringSlice converts a Ruby array of strings or symbols into a []string.
+func stringSlice(v *MrbValue) ([]string, error) {
+	if v.Type() != TypeArray {
+		return nil, fmt.Errorf("not an array type (%v)", v.Type())
+	}
+
+	array := v.Array()
+	result := make([]string, array.Len())
+	for i := range result {
+		item, err := array.Get(i)
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = item.String()
+	}
+
+	return result, nil
 }
 
 //-------------------------------------------------------------------
 // Native Go types implementing the Value interface
 //-------------------------------------------------------------------
 
+// MrbValue returns the native MRB value
+func (b Bool) MrbValue(m *Mrb) *MrbValue {
+	if b {
+		return m.TrueValue()
+	}
+
+	return m.FalseValue()
+}
+
+// MrbValue returns the native MRB value
+func (b Bytes) MrbValue(m *Mrb) *MrbValue {
+	return m.BytesValue([]byte(b))
+}
+
+// MrbValue returns the native MRB value
+func (f Float) MrbValue(m *Mrb) *MrbValue {
+	return m.FloatValue(float64(f))
+}
+
 // MrbValue returns the native MRB value
 func (i Int) MrbValue(m *Mrb) *MrbValue {
 	return m.FixnumValue(int(i))
@@ -243,6 +619,11 @@ func (s String) MrbValue(m *Mrb) *MrbValue {
 	return m.StringValue(string(s))
 }
 
+// MrbValue returns the native MRB value
+func (s Symbol) MrbValue(m *Mrb) *MrbValue {
+	return m.SymbolValue(string(s))
+}
+
 //-------------------------------------------------------------------
 // Internal Functions
 //-------------------------------------------------------------------
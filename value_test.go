@@ -1,7 +1,10 @@
 package mruby
 
 import (
+	"bytes"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -155,6 +158,131 @@ func TestMrbValueString(t *testing.T) {
 	}
 }
 
+func TestMrbValueString_binary(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`"foo\0bar\xff"`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.String() != "foo\x00bar\xff" {
+		t.Fatalf("bad: %q", value.String())
+	}
+}
+
+func TestMrbValueString_raise(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`
+		class Hello
+			def to_s; raise "nope"; end
+		end
+		Hello.new
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if s := value.String(); !strings.HasPrefix(s, "#<Hello") {
+		t.Fatalf("bad: %s", s)
+	}
+
+	// The VM should still be usable
+	if _, err := mrb.LoadString(`1`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestMrbValueToS(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	s, err := mrb.SymbolValue("foo").ToS()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if s != "foo" {
+		t.Fatalf("bad: %s", s)
+	}
+
+	value, err := mrb.LoadString(`
+		class Hello
+			def to_s; raise "nope"; end
+		end
+		Hello.new
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = value.ToS()
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if err.Error() != "nope" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// The VM should still be usable
+	if _, err := mrb.LoadString(`1`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestMrbValueSetInstanceVariable(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	obj, err := mrb.LoadString(`Object.new`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := obj.SetInstanceVariable("@foo", mrb.FixnumValue(1)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := mrb.FixnumValue(1).SetInstanceVariable("@foo", obj); err == nil {
+		t.Fatal("should error")
+	}
+
+	value, err := mrb.LoadString(`Object.new.respond_to?(:freeze)`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value.Bool() {
+		frozen, err := obj.Call("freeze")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := frozen.SetInstanceVariable("@foo", obj); err == nil {
+			t.Fatal("should error on frozen object")
+		}
+	}
+}
+
+func TestMrbValueBytes(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`"\0\xfe\xff"`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(value.Bytes(), []byte{0, 0xfe, 0xff}) {
+		t.Fatalf("bad: %#v", value.Bytes())
+	}
+
+	value, err = mrb.LoadString(`42`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(value.Bytes()) != "42" {
+		t.Fatalf("bad: %#v", value.Bytes())
+	}
+}
+
 func TestMrbValueType(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -247,6 +375,102 @@ func TestMrbValueType(t *testing.T) {
 	}
 }
 
+func TestMrbValueBool(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	cases := []struct {
+		Input  string
+		Bool   bool
+		Truthy bool
+	}{
+		{`true`, true, true},
+		{`false`, false, false},
+		{`nil`, false, false},
+		{`0`, false, true},
+		{`""`, false, true},
+		{`[]`, false, true},
+	}
+
+	for _, tc := range cases {
+		value, err := mrb.LoadString(tc.Input)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if value.Bool() != tc.Bool {
+			t.Fatalf("bad bool for %s: %v", tc.Input, value.Bool())
+		}
+		if value.Truthy() != tc.Truthy {
+			t.Fatalf("bad truthy for %s: %v", tc.Input, value.Truthy())
+		}
+	}
+}
+
+func TestBoolMrbValue(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var value Value = Bool(true)
+	if v := value.MrbValue(mrb); v.Type() != TypeTrue {
+		t.Fatalf("bad type: %v", v.Type())
+	}
+
+	value = Bool(false)
+	if v := value.MrbValue(mrb); v.Type() != TypeFalse {
+		t.Fatalf("bad type: %v", v.Type())
+	}
+}
+
+func TestBytesMrbValue(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var value Value = Bytes{'a', 0, 'b'}
+	v := value.MrbValue(mrb)
+	if v.Type() != TypeString {
+		t.Fatalf("bad type: %v", v.Type())
+	}
+	if v.String() != "a\x00b" {
+		t.Fatalf("bad: %q", v.String())
+	}
+}
+
+func TestFloatMrbValue(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var value Value = Float(1.5)
+	v := value.MrbValue(mrb)
+	if v.Type() != TypeFloat {
+		t.Fatalf("bad type: %v", v.Type())
+	}
+	if v.Float() != 1.5 {
+		t.Fatalf("bad: %f", v.Float())
+	}
+}
+
+func TestSymbolMrbValue(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var value Value = Symbol("foo")
+	v := value.MrbValue(mrb)
+	if v.Type() != TypeSymbol {
+		t.Fatalf("bad type: %v", v.Type())
+	}
+
+	result, err := v.Call("==", mrb.SymbolValue("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !result.Bool() {
+		t.Fatal("symbols should be equal")
+	}
+	if v.String() != "foo" {
+		t.Fatalf("bad: %s", v.String())
+	}
+}
+
 func TestIntMrbValue(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -283,6 +507,19 @@ func TestValueClass(t *testing.T) {
 	}
 }
 
+func TestValueSingletonClass_immediate(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	defer func() {
+		if _, ok := recover().(*Exception); !ok {
+			t.Fatal("should panic with an *Exception")
+		}
+	}()
+
+	mrb.FixnumValue(1).SingletonClass()
+}
+
 func TestValueSingletonClass(t *testing.T) {
 	mrb := NewMrb()
 	defer mrb.Close()
@@ -303,3 +540,391 @@ func TestValueSingletonClass(t *testing.T) {
 		t.Fatalf("Result %q was not equal to the target value of 48", result.String())
 	}
 }
+
+func TestMrbValueIntrospection(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value, err := mrb.LoadString(`
+		class Hello
+			def initialize; @a = 1; @b = "two"; end
+			def foo; end
+		end
+		Hello.new
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	inspect, err := value.Inspect()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.HasPrefix(inspect, "#<Hello") {
+		t.Fatalf("bad: %s", inspect)
+	}
+
+	ok, err := value.RespondTo("foo")
+	if err != nil || !ok {
+		t.Fatalf("should respond to foo: %s", err)
+	}
+	ok, err = value.RespondTo("bar")
+	if err != nil || ok {
+		t.Fatalf("should not respond to bar: %s", err)
+	}
+
+	ok, err = value.IsA(mrb.ObjectClass())
+	if err != nil || !ok {
+		t.Fatalf("should be an Object: %s", err)
+	}
+	ok, err = value.IsA(mrb.DefineClass("Other", nil))
+	if err != nil || ok {
+		t.Fatalf("should not be an Other: %s", err)
+	}
+
+	ivars, err := value.InstanceVariables()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(ivars) != 2 || ivars["@a"].Fixnum() != 1 || ivars["@b"].String() != "two" {
+		t.Fatalf("bad: %#v", ivars)
+	}
+
+	methods, err := value.Methods()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	found := false
+	for _, m := range methods {
+		found = found || m == "foo"
+	}
+	if !found {
+		t.Fatalf("bad: %#v", methods)
+	}
+
+	if value.ObjectID() != value.ObjectID() || value.ObjectID() == mrb.TopSelf().ObjectID() {
+		t.Fatal("bad object id")
+	}
+}
+
+func TestMrbValueEqual(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value := mrb.FixnumValue(1)
+
+	ok, err := value.Equal(Float(1))
+	if err != nil || !ok {
+		t.Fatalf("1 == 1.0: %s", err)
+	}
+	ok, err = value.Eql(Float(1))
+	if err != nil || ok {
+		t.Fatalf("1.eql?(1.0): %s", err)
+	}
+	ok, err = value.Eql(Int(1))
+	if err != nil || !ok {
+		t.Fatalf("1.eql?(1): %s", err)
+	}
+
+	a, err := mrb.StringValue("foo").HashCode()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	b, err := mrb.StringValue("foo").HashCode()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if a != b {
+		t.Fatalf("bad: %d != %d", a, b)
+	}
+}
+
+func TestMrbValueFreeze(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	value := mrb.StringValue("foo")
+	if ok, _ := value.RespondTo("freeze"); !ok {
+		// mruby 1.2 can't freeze values
+		isNoMethod := func(err error) bool {
+			exc, ok := err.(*Exception)
+			return ok && exc.Class().Name() == "NoMethodError"
+		}
+		if _, err := value.Frozen(); !isNoMethod(err) {
+			t.Fatalf("bad: %#v", err)
+		}
+		if err := value.Freeze(); !isNoMethod(err) {
+			t.Fatalf("bad: %#v", err)
+		}
+		return
+	}
+
+	if frozen, err := value.Frozen(); err != nil || frozen {
+		t.Fatalf("should not be frozen: %s", err)
+	}
+	if err := value.Freeze(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if frozen, err := value.Frozen(); err != nil || !frozen {
+		t.Fatalf("should be frozen: %s", err)
+	}
+}
+
+func TestMrbValueExtend(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	module := mrb.DefineModule("Mixin")
+	module.DefineMethod("foo", testCallback, ArgsNone())
+
+	obj, err := mrb.LoadString(`Object.new`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := obj.Extend(module); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	value, err := obj.Call("foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testCallbackResult(t, value)
+
+	if _, err := mrb.LoadString(`Object.new.foo`); err == nil {
+		t.Fatal("other objects should not be extended")
+	}
+}
+
+func TestMrbValueCall_manyArgs(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	var count int
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		count = len(m.GetArgs())
+		return Int(count), nil
+	}
+	mrb.TopSelf().SingletonClass().DefineMethod("count_args", cb, ArgsAny())
+
+	_, err := mrb.LoadString(`
+		def sum(*args, &blk)
+			total = 0
+			args.each { |x| total += x }
+			blk ? blk.call(total) : total
+		end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	args := make([]Value, 150)
+	for i := range args {
+		args[i] = Int(i + 1)
+	}
+
+	result, err := mrb.TopSelf().Call("sum", args...)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Fixnum() != 11325 {
+		t.Fatalf("bad: %s", result)
+	}
+
+	block, err := mrb.LoadString(`Proc.new { |x| x * 2 }`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result, err = mrb.TopSelf().CallBlock("sum", append(args, block)...)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Fixnum() != 22650 {
+		t.Fatalf("bad: %s", result)
+	}
+
+	// Go functions get all the arguments, whether they are called from Go
+	// or from Ruby.
+	if _, err := mrb.TopSelf().Call("count_args", args...); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if count != 150 {
+		t.Fatalf("bad: %d", count)
+	}
+
+	if _, err := mrb.LoadString(`count_args(*(1..120).to_a)`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if count != 120 {
+		t.Fatalf("bad: %d", count)
+	}
+
+	// Exceptions still come through
+	if _, err := mrb.TopSelf().Call("nope", args...); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestMrbYield_manyArgs(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	block, err := mrb.LoadString(`Proc.new { |*args| args.size }`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	args := make([]Value, 100)
+	for i := range args {
+		args[i] = Int(i)
+	}
+
+	result, err := mrb.Yield(block, args...)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Fixnum() != 100 {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
+func TestMrbValueCallSym(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	_, err := mrb.LoadString(`
+		def args(*a); a; end
+		def outer(x); inner(x + 1) * 10; end
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The fast path is reentrant: inner calls back into CallSym while
+	// outer is still running.
+	inner := mrb.Intern("args")
+	cb := func(m *Mrb, self *MrbValue) (Value, Value) {
+		result, err := self.CallSym(inner, m.GetArgs()[0], Int(7), Int(8))
+		if err != nil {
+			return nil, err.(*Exception).MrbValue
+		}
+
+		return Int(result.Array().Len()), nil
+	}
+	mrb.TopSelf().SingletonClass().DefineMethod("inner", cb, ArgsReq(1))
+
+	sym := mrb.Intern("args")
+	for n := 0; n <= 5; n++ {
+		args := make([]Value, n)
+		for i := range args {
+			args[i] = Int(i)
+		}
+
+		result, err := mrb.TopSelf().CallSym(sym, args...)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if result.String() != fmt.Sprintf("%v", intRange(n)) {
+			t.Fatalf("bad %d: %s", n, result)
+		}
+	}
+
+	result, err := mrb.TopSelf().CallSym(mrb.Intern("outer"), Int(1))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Fixnum() != 30 {
+		t.Fatalf("bad: %s", result)
+	}
+
+	_, err = mrb.TopSelf().CallSym(mrb.Intern("nope"), Int(1))
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if _, ok := err.(*Exception); !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestMrbValueCallSym_allocs(t *testing.T) {
+	mrb := NewMrb()
+	defer mrb.Close()
+
+	if _, err := mrb.LoadString(`def args(*a); nil; end`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	self := mrb.TopSelf()
+	sym := mrb.Intern("args")
+	values := []Value{mrb.FixnumValue(1), mrb.FixnumValue(2), mrb.FixnumValue(3)}
+
+	// The result is the only allocation when the arguments are already
+	// *MrbValues.
+	ai := mrb.ArenaSave()
+	for n := 0; n <= len(values); n++ {
+		args := values[:n]
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, err := self.CallSym(sym, args...); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			mrb.ArenaRestore(ai)
+		})
+		if allocs != 1 {
+			t.Fatalf("bad %d args: %v allocs", n, allocs)
+		}
+	}
+}
+
+// intRange returns 0 through n-1 formatted like a Ruby array.
+func intRange(n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%d", i)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func benchmarkCallVM(b *testing.B) (*Mrb, *MrbValue, []Value) {
+	mrb := NewMrb()
+	if _, err := mrb.LoadString(`def evaluate(a, b); a; end`); err != nil {
+		mrb.Close()
+		b.Fatalf("err: %s", err)
+	}
+
+	return mrb, mrb.TopSelf(), []Value{mrb.FixnumValue(1), mrb.FixnumValue(2)}
+}
+
+func BenchmarkMrbValueCall(b *testing.B) {
+	mrb, self, args := benchmarkCallVM(b)
+	defer mrb.Close()
+
+	b.ReportAllocs()
+	ai := mrb.ArenaSave()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := self.Call("evaluate", args...); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+
+		mrb.ArenaRestore(ai)
+	}
+}
+
+func BenchmarkMrbValueCallSym(b *testing.B) {
+	mrb, self, args := benchmarkCallVM(b)
+	defer mrb.Close()
+
+	sym := mrb.Intern("evaluate")
+
+	b.ReportAllocs()
+	ai := mrb.ArenaSave()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := self.CallSym(sym, args...); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+
+		mrb.ArenaRestore(ai)
+	}
+}